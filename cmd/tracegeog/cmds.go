@@ -4,29 +4,56 @@ import (
 	"bufio"
 	"context"
 	"flag"
+	"fmt"
+	"image"
 	_ "image/jpeg"
 	_ "image/png"
+	"io/ioutil"
 	"log"
 	"os"
+	"os/signal"
 
+	"github.com/golang/freetype/truetype"
 	"github.com/google/subcommands"
 	"github.com/uluyol/tracegeog/conversion/repetita"
 	"github.com/uluyol/tracegeog/tracer"
 	"github.com/uluyol/tracegeog/unproject"
 	"github.com/uluyol/tracegeog/visualize"
+	"golang.org/x/image/font/gofont/goregular"
 )
 
 type TraceNodes struct {
 	ImageReadingCmd
 	GraphWritingCmd
-
-	NodeIconPath      string
-	NodeColorAccuracy float64
-	MaxNodeCount      int
+	MaskingCmd
+
+	NodeIconPath       string
+	NodeColorString    string
+	NodeMinAreaPx      int
+	NodeMaxAreaPx      int
+	CircleMinRadiusPx  int
+	CircleMaxRadiusPx  int
+	CircleEdgeStrength float64
+	NodeColorAccuracy  float64
+	MaxNodeCount       int
+	MatchMetric        string
+	NumRunnersUp       int
+	AutoThreshold      bool
+	MinNodeSeparation  float64
+
+	IconMinScale  float64
+	IconMaxScale  float64
+	IconScaleStep float64
+
+	IconRotationStepDeg float64
+
+	UseFFT bool
 
 	TransitNodeIconPath      string
 	TransitNodeColorAccuracy float64
 	MaxTransitNodeCount      int
+
+	Classes classList
 }
 
 func (c *TraceNodes) Name() string     { return "trace-nodes" }
@@ -36,27 +63,82 @@ func (c *TraceNodes) Usage() string    { return c.Synopsis() + "\n" }
 func (c *TraceNodes) SetFlags(fs *flag.FlagSet) {
 	c.ImageReadingCmd.SetFlags(fs)
 	c.GraphWritingCmd.SetFlags(fs)
+	c.MaskingCmd.SetFlags(fs)
 
 	fs.StringVar(&c.NodeIconPath, "icon", "", "path to node icon image (png or jpeg)")
+	fs.StringVar(&c.NodeColorString, "node-color", "", "node color (alternative to -icon, finds solid blobs of this color)")
+	fs.IntVar(&c.NodeMinAreaPx, "node-min-area", 4, "minimum node area when using -node-color (pixels)")
+	fs.IntVar(&c.NodeMaxAreaPx, "node-max-area", 0, "maximum node area when using -node-color (pixels; 0 for no limit)")
+	fs.IntVar(&c.CircleMaxRadiusPx, "circle-max-radius", 0, "if positive, find circular nodes up to this radius (alternative to -icon)")
+	fs.IntVar(&c.CircleMinRadiusPx, "circle-min-radius", 3, "minimum radius of circular nodes (pixels)")
+	fs.Float64Var(&c.CircleEdgeStrength, "circle-edge-strength", 0.1, "minimum edge strength for circle outlines (0-1)")
 	fs.Float64Var(&c.NodeColorAccuracy, "node-color-accuracy", 0.8, "minimum node color accuracy")
 	fs.IntVar(&c.MaxNodeCount, "max-node-count", 0, "max node count (prunes if more than this are availabe)")
+	fs.IntVar(&c.NumRunnersUp, "runners-up", 0, "number of unselected candidates to record (for review with vis -color-by-score)")
+	fs.StringVar(&c.MatchMetric, "match-metric", "color", "icon match metric (color or ncc)")
+	fs.Float64Var(&c.MinNodeSeparation, "min-node-separation", 0,
+		"if positive, suppress candidates this close to a selected node instead of erasing nodes from the image (pixels; finds touching markers)")
+	fs.BoolVar(&c.AutoThreshold, "auto-threshold", false,
+		"choose thresholds at the natural break in candidate scores (accuracy flags become lower bounds, max counts of 0 mean no limit, and tracing fails without a max count if there is no break)")
+
+	fs.Float64Var(&c.IconMinScale, "icon-min-scale", 1, "smallest scale to search for icons at")
+	fs.Float64Var(&c.IconMaxScale, "icon-max-scale", 1, "largest scale to search for icons at")
+	fs.Float64Var(&c.IconScaleStep, "icon-scale-step", 0.05, "step between searched icon scales")
+	fs.BoolVar(&c.UseFFT, "fft", false, "skip icon locations that cannot match using FFT correlation (faster on large images)")
+	fs.Float64Var(&c.IconRotationStepDeg, "icon-rotation-step", 0, "if positive, search for icons rotated by multiples of this (degrees)")
 
 	fs.StringVar(&c.TransitNodeIconPath, "transit-icon", "", "path to transit icon (png or jpeg; optional)")
 	fs.Float64Var(&c.TransitNodeColorAccuracy, "transit-color-accuracy", 0.8, "minimum transit node color accuracy")
 	fs.IntVar(&c.MaxTransitNodeCount, "max-transit-count", 0, "max transit node count (prunes if more than this are available)")
+
+	fs.Var(&c.Classes, "class", "additional node class as name=NAME,icon=PATH|color=#RRGGBB[,threshold=X][,max=N] (may be repeated)")
+}
+
+type ExtractIcon struct {
+	ImageReadingCmd
+
+	OutputPath    string
+	X, Y          int
+	ColorAccuracy float64
+	MaxIconSizePx int
+}
+
+func (c *ExtractIcon) Name() string     { return "extract-icon" }
+func (c *ExtractIcon) Synopsis() string { return "extract a node icon from a point on the image" }
+func (c *ExtractIcon) Usage() string    { return c.Synopsis() + "\n" }
+
+func (c *ExtractIcon) SetFlags(fs *flag.FlagSet) {
+	c.ImageReadingCmd.SetFlags(fs)
+
+	fs.StringVar(&c.OutputPath, "o", "", "path to output icon png")
+	fs.IntVar(&c.X, "x", -1, "x coordinate of a pixel inside the node marker")
+	fs.IntVar(&c.Y, "y", -1, "y coordinate of a pixel inside the node marker")
+	fs.Float64Var(&c.ColorAccuracy, "color-accuracy", 0.9, "minimum color accuracy relative to the chosen pixel to include a pixel in the marker")
+	fs.IntVar(&c.MaxIconSizePx, "max-size", 100, "maximum icon width and height (pixels)")
 }
 
 type TraceLinks struct {
 	ImageReadingCmd
 	GraphReadingCmd
 	GraphWritingCmd
+	MaskingCmd
 
 	LineColorString      string
 	LineColorAccuracy    float64
+	LineClasses          lineClassList
 	LineWidthPx          int
 	LineAllowedGapPx     int
 	NodeProximityPx      int
 	ExpectedDirectionDeg float64
+	MaxDashGapPx         int
+	RouteTolerancePx     float64
+	ParallelSepPx        float64
+	Method               string
+	Tracker              string
+	NarrowRunSteps       bool
+	MaxLinkLenPx         float64
+	OffLineCost          float64
+	MaxPathCost          float64
 }
 
 func (c *TraceLinks) Name() string     { return "trace-links" }
@@ -67,13 +149,76 @@ func (c *TraceLinks) SetFlags(fs *flag.FlagSet) {
 	c.ImageReadingCmd.SetFlags(fs)
 	c.GraphReadingCmd.SetFlags(fs)
 	c.GraphWritingCmd.SetFlags(fs)
+	c.MaskingCmd.SetFlags(fs)
 
 	fs.StringVar(&c.LineColorString, "line-color", "#000000", "line color")
 	fs.Float64Var(&c.LineColorAccuracy, "line-color-accuracy", 0.85, "minimum color accuracy to match line")
+	fs.Var(&c.LineClasses, "line-class", "named line color as name=NAME,color=#RRGGBB[,accuracy=X], replaces -line-color (may be repeated)")
 	fs.IntVar(&c.LineWidthPx, "line-width", 3, "minimum line width (pixels)")
 	fs.IntVar(&c.LineAllowedGapPx, "line-gap", 1, "maximum line gap (pixels)")
 	fs.IntVar(&c.NodeProximityPx, "line-node-dist", 1, "maximum distance between line and node (pixels)")
 	fs.Float64Var(&c.ExpectedDirectionDeg, "line-dir-deg", 10, "maximum permitted change in line direction")
+	fs.Float64Var(&c.RouteTolerancePx, "route-tolerance", 2, "maximum distance between a traced line and the polyline kept as its route (pixels)")
+	fs.Float64Var(&c.ParallelSepPx, "parallel-sep", 0, "minimum gap between parallel lines that are kept as separate links between the same nodes, 0 to not look for parallel links (pixels)")
+	fs.IntVar(&c.MaxDashGapPx, "line-dash-gap", 0, "maximum gap between evenly spaced dashes of a dashed line, 0 to not look for dashes (pixels)")
+	fs.StringVar(&c.Method, "link-method", "runs", "link tracing method (runs, path, or skeleton)")
+	fs.StringVar(&c.Tracker, "tracker", "lnn", "runs: line run tracker (lnn or mht)")
+	fs.BoolVar(&c.NarrowRunSteps, "narrow-steps", false, "runs: lnn: take narrower steps and allow turns up to -line-dir-deg, which follows lines through crossings but traces more wrong links on some maps")
+	fs.Float64Var(&c.MaxLinkLenPx, "max-link-len", 500, "path: maximum distance between linked nodes (pixels)")
+	fs.Float64Var(&c.OffLineCost, "off-line-cost", 10, "path: cost of a path pixel that is not on a line (1 on a line)")
+	fs.Float64Var(&c.MaxPathCost, "max-path-cost", 1.5, "path: maximum path cost per pixel of distance between the nodes")
+}
+
+type ReadLabels struct {
+	ImageReadingCmd
+	GraphReadingCmd
+	GraphWritingCmd
+	MaskingCmd
+
+	FontPath        string
+	FontSizePx      float64
+	Alphabet        string
+	MinStrength     float64
+	MaxDistPx       float64
+	MaxLabelWidthPx float64
+	NodeRadiusPx    float64
+}
+
+func (c *ReadLabels) Name() string     { return "read-labels" }
+func (c *ReadLabels) Synopsis() string { return "name nodes after nearby text in an image" }
+func (c *ReadLabels) Usage() string    { return c.Synopsis() + "\n" }
+
+func (c *ReadLabels) SetFlags(fs *flag.FlagSet) {
+	c.ImageReadingCmd.SetFlags(fs)
+	c.GraphReadingCmd.SetFlags(fs)
+	c.GraphWritingCmd.SetFlags(fs)
+	c.MaskingCmd.SetFlags(fs)
+
+	fs.StringVar(&c.FontPath, "font", "", "path to truetype font of the labels (default Go Regular)")
+	fs.Float64Var(&c.FontSizePx, "font-size", 12, "font size of the labels (pixels)")
+	fs.StringVar(&c.Alphabet, "alphabet", tracer.DefaultAlphabet, "characters to look for")
+	fs.Float64Var(&c.MinStrength, "label-min-strength", 0.8, "minimum correlation between a glyph and the image (0-1)")
+	fs.Float64Var(&c.MaxDistPx, "label-dist", 20, "maximum distance between a node and its label (pixels)")
+	fs.Float64Var(&c.MaxLabelWidthPx, "label-max-width", 150, "maximum label width (pixels)")
+	fs.Float64Var(&c.NodeRadiusPx, "node-radius", 10, "radius of node markers, which are not searched for text (pixels)")
+}
+
+type EvalLinks struct {
+	GraphReadingCmd
+
+	ReferenceGraph string
+	MatchDistPx    float64
+}
+
+func (c *EvalLinks) Name() string     { return "eval-links" }
+func (c *EvalLinks) Synopsis() string { return "compare traced links against a reference graph" }
+func (c *EvalLinks) Usage() string    { return c.Synopsis() + "\n" }
+
+func (c *EvalLinks) SetFlags(fs *flag.FlagSet) {
+	c.GraphReadingCmd.SetFlags(fs)
+
+	fs.StringVar(&c.ReferenceGraph, "ref", "", "path to reference graph with correct links")
+	fs.Float64Var(&c.MatchDistPx, "match-dist", 10, "max distance between matching nodes in pixels")
 }
 
 type Vis struct {
@@ -82,6 +227,7 @@ type Vis struct {
 
 	OutputImagePath        string
 	OutputOverlayImagePath string
+	ColorByScore           bool
 }
 
 func (c *Vis) Name() string     { return "vis" }
@@ -94,6 +240,7 @@ func (c *Vis) SetFlags(fs *flag.FlagSet) {
 
 	fs.StringVar(&c.OutputImagePath, "png", "", "path to output png")
 	fs.StringVar(&c.OutputOverlayImagePath, "overlaypng", "", "path to output overlay png")
+	fs.BoolVar(&c.ColorByScore, "color-by-score", false, "color nodes by match score (red is least confident) and show runners-up")
 }
 
 type Unproj struct {
@@ -131,9 +278,10 @@ func (c *Unproj) SetFlags(fs *flag.FlagSet) {
 type ExportRepetita struct {
 	GeoGraphReadingCmd
 
-	OutputPath      string
-	RefractiveIndex float64
-	MakeSymmetric   bool
+	OutputPath        string
+	RefractiveIndex   float64
+	MakeSymmetric     bool
+	CapacityTablePath string
 }
 
 func (c *ExportRepetita) Name() string     { return "export-repetita" }
@@ -150,41 +298,98 @@ func (c *ExportRepetita) SetFlags(fs *flag.FlagSet) {
 	fs.BoolVar(&c.MakeSymmetric, "make-sym",
 		repetita.DefaultExporter.MakeSymmetric,
 		"if true, will make links symmetric")
+	fs.StringVar(&c.CapacityTablePath, "capacity-table", "",
+		"path to table of line width (pixels) and bandwidth (kbps) pairs, one per line (optional)")
 }
 
 func (c *TraceNodes) Execute(ctx context.Context, fs *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
 	c.ImageReadingCmd.Prepare()
 
-	icon, err := readImage(c.NodeIconPath)
-	if err != nil {
-		log.Fatalf("unable to read icon: %v", err)
+	var classes []tracer.NodeClass
+	numModes := 0
+	for _, set := range []bool{c.NodeIconPath != "", c.NodeColorString != "", c.CircleMaxRadiusPx > 0} {
+		if set {
+			numModes++
+		}
 	}
-
-	tr := tracer.NewNode(tracer.NodeConfig{
-		Matcher:           tracer.NewIconMatcher(icon),
-		StrengthThreshold: c.NodeColorAccuracy,
-		MaxCount:          c.MaxNodeCount,
-	}, c.im, log.Printf)
-
-	tr.Find()
-	graph := tr.Graph()
-
-	if c.TransitNodeIconPath != "" {
-		transitIcon, err := readImage(c.TransitNodeIconPath)
-		if err != nil {
-			log.Fatalf("unable to read transit node icon: %v", err)
+	if numModes > 1 {
+		log.Fatalf("only one of -icon, -node-color, and -circle-max-radius may be set")
+	}
+	if numModes == 1 {
+		cl := tracer.NodeClass{
+			StrengthThreshold: c.NodeColorAccuracy,
+			MaxCount:          c.MaxNodeCount,
 		}
+		switch {
+		case c.CircleMaxRadiusPx > 0:
+			cl.Detector = &tracer.HoughCircleDetector{
+				MinRadiusPx:     c.CircleMinRadiusPx,
+				MaxRadiusPx:     c.CircleMaxRadiusPx,
+				MinEdgeStrength: c.CircleEdgeStrength,
+			}
+		case c.NodeColorString != "":
+			cl.Detector = c.colorDetector(c.NodeColorString, c.NodeColorAccuracy)
+		default:
+			cl.Matcher = c.readIconMatcher(c.NodeIconPath)
+		}
+		classes = append(classes, cl)
+	}
 
-		tr2 := tracer.NewNode(tracer.NodeConfig{
-			Matcher:           tracer.NewIconMatcher(transitIcon),
+	if c.TransitNodeIconPath != "" {
+		classes = append(classes, tracer.NodeClass{
+			Name:              tracer.TransitClass,
+			Matcher:           c.readIconMatcher(c.TransitNodeIconPath),
 			StrengthThreshold: c.TransitNodeColorAccuracy,
 			MaxCount:          c.MaxTransitNodeCount,
-		}, tr.Image(), log.Printf)
+		})
+	}
 
-		tr2.Find()
-		graph.AddAsTransitOnly(tr2.Graph())
+	for _, spec := range c.Classes {
+		cl := tracer.NodeClass{
+			Name:              spec.Name,
+			StrengthThreshold: c.NodeColorAccuracy,
+			MaxCount:          c.MaxNodeCount,
+		}
+		if spec.Threshold != 0 {
+			cl.StrengthThreshold = spec.Threshold
+		}
+		if spec.MaxCount != 0 {
+			cl.MaxCount = spec.MaxCount
+		}
+		if spec.IconPath != "" {
+			cl.Matcher = c.readIconMatcher(spec.IconPath)
+		} else {
+			cl.Detector = c.colorDetector(spec.Color, cl.StrengthThreshold)
+		}
+		classes = append(classes, cl)
 	}
 
+	if len(classes) == 0 {
+		log.Fatalf("must set one of -icon, -node-color, -circle-max-radius, -transit-icon, or -class")
+	}
+	for i := range classes {
+		classes[i].AutoThreshold = c.AutoThreshold
+	}
+
+	tr, err := tracer.NewNode(tracer.NodeConfig{
+		Classes:         classes,
+		Metric:          c.metric(),
+		NumRunnersUp:    c.NumRunnersUp,
+		MinSeparationPx: c.MinNodeSeparation,
+		Mask:            c.Mask(c.im.Bounds()),
+		Progress:        bar.Update,
+	}, c.im, log.Printf)
+	if err != nil {
+		log.Fatalf("unable to trace nodes: %v", err)
+	}
+
+	err = tr.Find(ctx)
+	bar.Done()
+	if err != nil {
+		log.Fatalf("unable to trace nodes: %v", err)
+	}
+	graph := tr.Graph()
+
 	if err := writeGraphTo(graph, c.OutputPath); err != nil {
 		log.Fatalf("unable to write output json file %s: %v",
 			c.OutputPath, err)
@@ -192,6 +397,80 @@ func (c *TraceNodes) Execute(ctx context.Context, fs *flag.FlagSet, args ...inte
 	return subcommands.ExitSuccess
 }
 
+func (c *TraceNodes) metric() tracer.MatchMetric {
+	switch c.MatchMetric {
+	case "color":
+		return tracer.MeanColorAccuracy
+	case "ncc":
+		return tracer.NormalizedCrossCorrelation
+	}
+	log.Fatalf("unknown match metric %q", c.MatchMetric)
+	panic("unreachable")
+}
+
+func (c *TraceNodes) colorDetector(colorString string, accuracy float64) tracer.BlobDetector {
+	nodeColor, err := parseHexColor(colorString)
+	if err != nil {
+		log.Fatalf("bad node color: %v", err)
+	}
+	return &tracer.ColorBlobDetector{
+		Color:            nodeColor,
+		MinColorAccuracy: accuracy,
+		MinAreaPx:        c.NodeMinAreaPx,
+		MaxAreaPx:        c.NodeMaxAreaPx,
+	}
+}
+
+func (c *TraceNodes) readIconMatcher(p string) tracer.BlobMatcher {
+	icon, err := readImage(p)
+	if err != nil {
+		log.Fatalf("unable to read icon %s: %v", p, err)
+	}
+	return c.iconMatcher(icon)
+}
+
+func (c *TraceNodes) iconMatcher(icon image.Image) tracer.BlobMatcher {
+	scaled := c.IconMinScale != 1 || c.IconMaxScale != 1
+	if c.UseFFT {
+		if scaled || c.IconRotationStepDeg > 0 || c.MatchMetric != "color" {
+			log.Fatalf("-fft cannot be combined with icon scaling, rotation, or other match metrics")
+		}
+		return tracer.NewFFTIconMatcher(icon)
+	}
+	if c.IconRotationStepDeg > 0 {
+		if scaled {
+			log.Fatalf("icon rotation cannot be combined with icon scaling")
+		}
+		var angles []float64
+		for a := 0.0; a < 360; a += c.IconRotationStepDeg {
+			angles = append(angles, a)
+		}
+		return tracer.NewRotatedIconMatcher(icon, angles)
+	}
+	if !scaled {
+		return tracer.NewIconMatcher(icon)
+	}
+	if c.IconMinScale <= 0 || c.IconMaxScale < c.IconMinScale || c.IconScaleStep <= 0 {
+		log.Fatalf("bad icon scale range [%g, %g] with step %g",
+			c.IconMinScale, c.IconMaxScale, c.IconScaleStep)
+	}
+	return tracer.NewScaledIconMatcher(icon, c.IconMinScale, c.IconMaxScale, c.IconScaleStep)
+}
+
+func (c *ExtractIcon) Execute(ctx context.Context, fs *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
+	c.ImageReadingCmd.Prepare()
+
+	icon, err := tracer.ExtractIcon(c.im, image.Pt(c.X, c.Y), c.ColorAccuracy, c.MaxIconSizePx)
+	if err != nil {
+		log.Fatalf("unable to extract icon: %v", err)
+	}
+	log.Printf("extracted %dx%d icon", icon.Rect.Dx(), icon.Rect.Dy())
+	if err := writePngTo(icon, c.OutputPath); err != nil {
+		log.Fatalf("unable to write icon to %s: %v", c.OutputPath, err)
+	}
+	return subcommands.ExitSuccess
+}
+
 func (c *TraceLinks) Execute(ctx context.Context, fs *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
 	c.ImageReadingCmd.Prepare()
 	c.GraphReadingCmd.Prepare()
@@ -200,17 +479,44 @@ func (c *TraceLinks) Execute(ctx context.Context, fs *flag.FlagSet, args ...inte
 	if err != nil {
 		log.Fatalf("bad line color: %v", err)
 	}
+	var classes []tracer.LineClass
+	for _, spec := range c.LineClasses {
+		cl := tracer.LineClass{Name: spec.Name, MinColorAccuracy: c.LineColorAccuracy}
+		if cl.Color, err = parseHexColor(spec.Color); err != nil {
+			log.Fatalf("bad color for line class %s: %v", spec.Name, err)
+		}
+		if spec.Accuracy != 0 {
+			cl.MinColorAccuracy = spec.Accuracy
+		}
+		classes = append(classes, cl)
+	}
 
 	tracer := tracer.NewLink(tracer.LinkConfig{
+		Method:               c.method(),
+		Tracker:              c.tracker(),
+		NarrowRunSteps:       c.NarrowRunSteps,
+		Classes:              classes,
 		Color:                lineColor,
 		MinColorAccuracy:     c.LineColorAccuracy,
 		MinWidthPx:           c.LineWidthPx,
 		AllowedGapPx:         c.LineAllowedGapPx,
 		NodeProximityPx:      c.NodeProximityPx,
 		ExpectedDirectionDeg: c.ExpectedDirectionDeg,
+		MaxDashGapPx:         c.MaxDashGapPx,
+		RouteTolerancePx:     c.RouteTolerancePx,
+		ParallelSepPx:        c.ParallelSepPx,
+		MaxLinkLenPx:         c.MaxLinkLenPx,
+		OffLineCost:          c.OffLineCost,
+		MaxPathCost:          c.MaxPathCost,
+		Mask:                 c.Mask(c.im.Bounds()),
+		Progress:             bar.Update,
 	}, c.im, &c.graph, log.Printf)
 
-	tracer.Find()
+	err = tracer.Find(ctx)
+	bar.Done()
+	if err != nil {
+		log.Fatalf("unable to trace links: %v", err)
+	}
 	graph := tracer.Graph()
 
 	if err := writeGraphTo(graph, c.OutputPath); err != nil {
@@ -221,11 +527,90 @@ func (c *TraceLinks) Execute(ctx context.Context, fs *flag.FlagSet, args ...inte
 	return subcommands.ExitSuccess
 }
 
+func (c *TraceLinks) method() tracer.LinkMethod {
+	switch c.Method {
+	case "runs":
+		return tracer.LineRuns
+	case "path":
+		return tracer.PathSearch
+	case "skeleton":
+		return tracer.Skeleton
+	}
+	log.Fatalf("unknown link method %q", c.Method)
+	panic("unreachable")
+}
+
+func (c *TraceLinks) tracker() tracer.RunTracker {
+	switch c.Tracker {
+	case "lnn":
+		return tracer.LNN
+	case "mht":
+		return tracer.MHT
+	}
+	log.Fatalf("unknown tracker %q", c.Tracker)
+	panic("unreachable")
+}
+
+func (c *ReadLabels) Execute(ctx context.Context, fs *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
+	c.ImageReadingCmd.Prepare()
+	c.GraphReadingCmd.Prepare()
+
+	ttf := goregular.TTF
+	if c.FontPath != "" {
+		var err error
+		ttf, err = ioutil.ReadFile(c.FontPath)
+		if err != nil {
+			log.Fatalf("unable to read font: %v", err)
+		}
+	}
+	font, err := truetype.Parse(ttf)
+	if err != nil {
+		log.Fatalf("unable to parse font: %v", err)
+	}
+
+	tr := tracer.NewLabel(tracer.LabelConfig{
+		Face:            truetype.NewFace(font, &truetype.Options{Size: c.FontSizePx}),
+		Alphabet:        c.Alphabet,
+		MinStrength:     c.MinStrength,
+		MaxDistPx:       c.MaxDistPx,
+		MaxLabelWidthPx: c.MaxLabelWidthPx,
+		NodeRadiusPx:    c.NodeRadiusPx,
+		Mask:            c.Mask(c.im.Bounds()),
+		Progress:        bar.Update,
+	}, c.im, &c.graph, log.Printf)
+
+	err = tr.Find(ctx)
+	bar.Done()
+	if err != nil {
+		log.Fatalf("unable to read labels: %v", err)
+	}
+	graph := tr.Graph()
+
+	if err := writeGraphTo(graph, c.OutputPath); err != nil {
+		log.Fatalf("unable to write output json file %s: %v",
+			c.OutputPath, err)
+	}
+	return subcommands.ExitSuccess
+}
+
+func (c *EvalLinks) Execute(ctx context.Context, fs *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
+	c.GraphReadingCmd.Prepare()
+	ref := GraphReadingCmd{InputGraph: c.ReferenceGraph}
+	ref.Prepare()
+
+	s := tracer.CompareLinks(&ref.graph, &c.graph, c.MatchDistPx)
+	fmt.Printf("reference %d traced %d matched %d\n", s.Want, s.Have, s.Match)
+	fmt.Printf("precision %.3f recall %.3f\n", s.Precision(), s.Recall())
+	return subcommands.ExitSuccess
+}
+
 func (c *Vis) Execute(ctx context.Context, fs *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
 	c.ImageReadingCmd.Prepare()
 	c.GraphReadingCmd.Prepare()
 
-	outIm := visualize.DrawGraph(&c.graph)
+	outIm := visualize.DrawGraph(&c.graph, visualize.Options{
+		ColorByScore: c.ColorByScore,
+	})
 	if err := writePngTo(outIm, c.OutputImagePath); err != nil {
 		log.Fatalf("unable to write png to %s: %v",
 			c.OutputImagePath, err)
@@ -263,7 +648,7 @@ func (c *Unproj) Execute(ctx context.Context, fs *flag.FlagSet, args ...interfac
 		wm.EquatorY = g.Bounds.Dy() / 2
 	}
 
-	geog := unproject.ToGeoGraph(&g, wm.ToLatLon)
+	geog := unproject.ToGeoGraph(&g, wm.ToLatLon, wm.WorldWidthPx())
 	if err := writeGraphTo(geog, c.OutputPath); err != nil {
 		log.Fatalf("failed to write output to %s: %v", c.OutputPath, err)
 	}
@@ -278,6 +663,13 @@ func (c *ExportRepetita) Execute(ctx context.Context, fs *flag.FlagSet, args ...
 		RefractiveIndex: c.RefractiveIndex,
 		MakeSymmetric:   c.MakeSymmetric,
 	}
+	if c.CapacityTablePath != "" {
+		t, err := readCapacityTable(c.CapacityTablePath)
+		if err != nil {
+			log.Fatalf("failed to read capacity table %s: %v", c.CapacityTablePath, err)
+		}
+		e.Capacities = t
+	}
 
 	f, err := os.Create(c.OutputPath)
 	bw := bufio.NewWriter(f)
@@ -297,12 +689,17 @@ func (c *ExportRepetita) Execute(ctx context.Context, fs *flag.FlagSet, args ...
 	return subcommands.ExitSuccess
 }
 
+var bar = newProgressBar(os.Stderr)
+
 func main() {
 	subcommands.Register(subcommands.HelpCommand(), "")
 	subcommands.Register(subcommands.FlagsCommand(), "")
 	subcommands.Register(subcommands.CommandsCommand(), "")
 	subcommands.Register(&TraceNodes{}, "")
+	subcommands.Register(&ExtractIcon{}, "")
 	subcommands.Register(&TraceLinks{}, "")
+	subcommands.Register(&ReadLabels{}, "")
+	subcommands.Register(&EvalLinks{}, "")
 	subcommands.Register(&Vis{}, "")
 	subcommands.Register(&Unproj{}, "")
 	subcommands.Register(&ExportRepetita{}, "")
@@ -311,7 +708,18 @@ func main() {
 
 	log.SetFlags(0)
 	log.SetPrefix("tracegeog: ")
+	log.SetOutput(bar)
+
+	// Stop tracing on the first interrupt. Later ones kill the process.
+	ctx, cancel := context.WithCancel(context.Background())
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt)
+	go func() {
+		<-sigs
+		signal.Stop(sigs)
+		log.Print("interrupted, stopping")
+		cancel()
+	}()
 
-	ctx := context.Background()
 	os.Exit(int(subcommands.Execute(ctx)))
 }
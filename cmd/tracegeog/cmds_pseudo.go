@@ -28,6 +28,12 @@ type GeoGraphReadingCmd struct {
 
 type GraphWritingCmd struct{ OutputPath string }
 
+type MaskingCmd struct {
+	Include  rectList
+	Exclude  rectList
+	MaskPath string
+}
+
 func (c *ImageReadingCmd) SetFlags(fs *flag.FlagSet) {
 	fs.StringVar(&c.InputPath, "i", "", "path to input image (png or jpeg)")
 }
@@ -44,6 +50,12 @@ func (c *GraphWritingCmd) SetFlags(fs *flag.FlagSet) {
 	fs.StringVar(&c.OutputPath, "o", "", "path to output json")
 }
 
+func (c *MaskingCmd) SetFlags(fs *flag.FlagSet) {
+	fs.Var(&c.Include, "include", "only trace within rectangle x0,y0,x1,y1 (may be repeated)")
+	fs.Var(&c.Exclude, "exclude", "do not trace within rectangle x0,y0,x1,y1 (may be repeated)")
+	fs.StringVar(&c.MaskPath, "mask", "", "path to mask image, dark or transparent pixels are not traced (optional)")
+}
+
 func (c *ImageReadingCmd) Prepare() {
 	im, err := readImage(c.InputPath)
 	if err != nil {
@@ -77,3 +89,26 @@ func (c *GeoGraphReadingCmd) Prepare() {
 	}
 	f.Close() // non-fatal if errors
 }
+
+// Mask returns the mask to use for an image with bounds b,
+// or nil if no masking is requested.
+func (c *MaskingCmd) Mask(b image.Rectangle) *tracer.Mask {
+	if len(c.Include) == 0 && len(c.Exclude) == 0 && c.MaskPath == "" {
+		return nil
+	}
+	m := tracer.NewMask(b)
+	if len(c.Include) > 0 {
+		m.IncludeOnly(c.Include)
+	}
+	for _, r := range c.Exclude {
+		m.Exclude(r)
+	}
+	if c.MaskPath != "" {
+		maskIm, err := readImage(c.MaskPath)
+		if err != nil {
+			log.Fatalf("unable to read mask: %v", err)
+		}
+		m.ExcludeImage(maskIm)
+	}
+	return m
+}
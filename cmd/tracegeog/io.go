@@ -6,8 +6,13 @@ import (
 	"image"
 	"image/color"
 	"image/png"
+	"io/ioutil"
 	"log"
 	"os"
+	"strconv"
+	"strings"
+
+	"github.com/uluyol/tracegeog/conversion/repetita"
 )
 
 func readImage(p string) (image.Image, error) {
@@ -39,6 +44,139 @@ func parseHexColor(s string) (c color.RGBA, err error) {
 	return
 }
 
+type rectList []image.Rectangle
+
+func (l *rectList) String() string { return fmt.Sprint(*l) }
+
+func (l *rectList) Set(s string) error {
+	var r image.Rectangle
+	_, err := fmt.Sscanf(s, "%d,%d,%d,%d", &r.Min.X, &r.Min.Y, &r.Max.X, &r.Max.Y)
+	if err != nil {
+		return fmt.Errorf("invalid rectangle %q, must be x0,y0,x1,y1: %v", s, err)
+	}
+	*l = append(*l, r.Canon())
+	return nil
+}
+
+// A classSpec describes a node class as comma-separated key=value pairs,
+// e.g. "name=pop,icon=pop.png,threshold=0.9,max=20".
+type classSpec struct {
+	Name      string
+	IconPath  string
+	Color     string
+	Threshold float64 // 0 if unset
+	MaxCount  int     // 0 if unset
+}
+
+type classList []classSpec
+
+func (l *classList) String() string { return fmt.Sprint(*l) }
+
+func (l *classList) Set(s string) error {
+	var spec classSpec
+	for _, kv := range strings.Split(s, ",") {
+		i := strings.Index(kv, "=")
+		if i < 0 {
+			return fmt.Errorf("invalid class field %q, must be key=value", kv)
+		}
+		k, v := kv[:i], kv[i+1:]
+		var err error
+		switch k {
+		case "name":
+			spec.Name = v
+		case "icon":
+			spec.IconPath = v
+		case "color":
+			spec.Color = v
+		case "threshold":
+			spec.Threshold, err = strconv.ParseFloat(v, 64)
+		case "max":
+			spec.MaxCount, err = strconv.Atoi(v)
+		default:
+			return fmt.Errorf("unknown class field %q", k)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid class field %q: %v", kv, err)
+		}
+	}
+	if spec.Name == "" {
+		return fmt.Errorf("class %q has no name", s)
+	}
+	if (spec.IconPath == "") == (spec.Color == "") {
+		return fmt.Errorf("class %q must have exactly one of icon and color", s)
+	}
+	*l = append(*l, spec)
+	return nil
+}
+
+// A lineClassSpec describes a line class as comma-separated key=value
+// pairs, e.g. "name=subsea,color=#1f77b4,accuracy=0.8".
+type lineClassSpec struct {
+	Name     string
+	Color    string
+	Accuracy float64 // 0 if unset
+}
+
+type lineClassList []lineClassSpec
+
+func (l *lineClassList) String() string { return fmt.Sprint(*l) }
+
+func (l *lineClassList) Set(s string) error {
+	var spec lineClassSpec
+	for _, kv := range strings.Split(s, ",") {
+		i := strings.Index(kv, "=")
+		if i < 0 {
+			return fmt.Errorf("invalid line class field %q, must be key=value", kv)
+		}
+		k, v := kv[:i], kv[i+1:]
+		var err error
+		switch k {
+		case "name":
+			spec.Name = v
+		case "color":
+			spec.Color = v
+		case "accuracy":
+			spec.Accuracy, err = strconv.ParseFloat(v, 64)
+		default:
+			return fmt.Errorf("unknown line class field %q", k)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid line class field %q: %v", kv, err)
+		}
+	}
+	if spec.Name == "" {
+		return fmt.Errorf("line class %q has no name", s)
+	}
+	if spec.Color == "" {
+		return fmt.Errorf("line class %q has no color", s)
+	}
+	*l = append(*l, spec)
+	return nil
+}
+
+// readCapacityTable reads a table of line widths (pixels) and bandwidths
+// (kbps), one pair per line. Blank lines and lines starting with # are
+// skipped.
+func readCapacityTable(p string) (repetita.CapacityTable, error) {
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	var t repetita.CapacityTable
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var c repetita.Capacity
+		if _, err := fmt.Sscan(line, &c.WidthPx, &c.BandwidthKbps); err != nil {
+			return nil, fmt.Errorf("line %d: must be width and bandwidth: %v", i+1, err)
+		}
+		t = append(t, c)
+	}
+	return t, nil
+}
+
 func writeGraphTo(graph interface{}, p string) error {
 	log.Printf("writing graph to %s", p)
 
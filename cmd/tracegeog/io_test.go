@@ -0,0 +1,40 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/uluyol/tracegeog/conversion/repetita"
+)
+
+func TestReadCapacityTable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tracegeog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	p := filepath.Join(dir, "capacities")
+	data := "# width bandwidth\n1 1000\n\n  4.5 10000\n"
+	if err := ioutil.WriteFile(p, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	have, err := readCapacityTable(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := repetita.CapacityTable{{WidthPx: 1, BandwidthKbps: 1000}, {WidthPx: 4.5, BandwidthKbps: 10000}}
+	if !reflect.DeepEqual(have, want) {
+		t.Errorf("want %v, have %v", want, have)
+	}
+
+	if err := ioutil.WriteFile(p, []byte("1 fast\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readCapacityTable(p); err == nil {
+		t.Errorf("want error for bad bandwidth")
+	}
+}
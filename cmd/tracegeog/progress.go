@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/uluyol/tracegeog/tracer"
+)
+
+// A progressBar draws tracer progress on the last line of a terminal.
+// It is also an io.Writer for log output so that log lines are printed
+// above the bar.
+type progressBar struct {
+	mu      sync.Mutex
+	w       io.Writer
+	enabled bool
+	p       tracer.Progress
+	line    string // currently drawn
+}
+
+// newProgressBar returns a progressBar that writes to f and only draws
+// if f is a terminal.
+func newProgressBar(f *os.File) *progressBar {
+	return &progressBar{w: f, enabled: isTerminal(f)}
+}
+
+// isTerminal returns whether f is a character device, which is good
+// enough to tell whether to draw a progress bar.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+func (b *progressBar) Update(p tracer.Progress) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.p = p
+	b.draw()
+}
+
+// Done clears the bar.
+func (b *progressBar) Done() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.clear()
+	b.p = tracer.Progress{}
+}
+
+func (b *progressBar) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.clear()
+	n, err := b.w.Write(p)
+	b.draw()
+	return n, err
+}
+
+func (b *progressBar) clear() {
+	if b.line != "" {
+		fmt.Fprint(b.w, "\r\033[K")
+		b.line = ""
+	}
+}
+
+func (b *progressBar) draw() {
+	if !b.enabled || b.p.Stage == "" {
+		return
+	}
+	const width = 30
+	var line string
+	if b.p.Total > 0 { // not tracer.UnknownTotal
+		done := b.p.Done
+		if done < 0 {
+			done = 0
+		} else if done > b.p.Total {
+			done = b.p.Total
+		}
+		filled := width * done / b.p.Total
+		line = fmt.Sprintf("%s [%s%s] %3d%%", b.p.Stage,
+			strings.Repeat("#", filled), strings.Repeat(" ", width-filled),
+			100*done/b.p.Total)
+	} else {
+		line = fmt.Sprintf("%s: %d", b.p.Stage, b.p.Done)
+	}
+	if line == b.line {
+		return
+	}
+	fmt.Fprint(b.w, "\r\033[K"+line)
+	b.line = line
+}
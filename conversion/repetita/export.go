@@ -21,7 +21,9 @@ package repetita
 import (
 	"fmt"
 	"io"
+	"math"
 	"sort"
+	"strings"
 
 	"github.com/uluyol/tracegeog/unproject"
 )
@@ -31,6 +33,37 @@ type Exporter struct {
 	RefractiveIndex float64
 
 	MakeSymmetric bool
+
+	// Capacities gives the bandwidth of links by the width of their line.
+	// Links get DefaultBandwidthKbps if it is empty or their width is not
+	// known.
+	Capacities CapacityTable
+}
+
+// DefaultBandwidthKbps is the bandwidth of links of unknown capacity.
+const DefaultBandwidthKbps = 1000000
+
+// A Capacity is the bandwidth of links drawn with lines of some width.
+type Capacity struct {
+	WidthPx       float64
+	BandwidthKbps int64
+}
+
+// A CapacityTable maps line widths to bandwidths. Each link gets the
+// bandwidth of the entry whose width is closest to that of its line.
+type CapacityTable []Capacity
+
+func (t CapacityTable) bandwidthKbps(widthPx float64) int64 {
+	if widthPx <= 0 || len(t) == 0 {
+		return DefaultBandwidthKbps
+	}
+	best := t[0]
+	for _, c := range t[1:] {
+		if math.Abs(c.WidthPx-widthPx) < math.Abs(best.WidthPx-widthPx) {
+			best = c
+		}
+	}
+	return best.BandwidthKbps
 }
 
 func (e *Exporter) WriteGeo(g *unproject.GeoGraph, w io.Writer) error {
@@ -52,10 +85,12 @@ func (e *Exporter) WriteGeo(g *unproject.GeoGraph, w io.Writer) error {
 
 	writef("NODES %d\n", len(g.Nodes))
 	writef("label x y\n")
-	for i := range g.Nodes {
-		name := "node"
+	for i, n := range g.Nodes {
+		name := label(n.Class)
 		if isTransit[i] {
 			name = "transit"
+		} else if name == "" {
+			name = "node"
 		}
 		writef("%s_%d 0 0\n", name, i)
 	}
@@ -66,50 +101,81 @@ func (e *Exporter) WriteGeo(g *unproject.GeoGraph, w io.Writer) error {
 	}
 	links = append([]unproject.Link(nil), links...)
 
-	sort.Slice(links, func(i, j int) bool {
-		if links[i].Src == links[j].Src {
+	sort.SliceStable(links, func(i, j int) bool {
+		if links[i].Src != links[j].Src {
+			return links[i].Src < links[j].Src
+		}
+		if links[i].Dst != links[j].Dst {
 			return links[i].Dst < links[j].Dst
 		}
-		return links[i].Src < links[j].Src
+		return links[i].Class < links[j].Class
 	})
 
 	writef("\nEDGES %d\n", len(links))
 	writef("label src dest weight bw delay\n")
 	for i, l := range links {
-		writef("edge_%d %d %d 0 1000000 %d\n", i, l.Src, l.Dst, e.delayMicros(g, l))
+		name := label(l.Class)
+		if name == "" {
+			name = "edge"
+		}
+		writef("%s_%d %d %d 0 %d %d\n", name, i, l.Src, l.Dst,
+			e.Capacities.bandwidthKbps(l.WidthPx), e.delayMicros(g, l))
 	}
 
 	return err
 }
 
+// label makes name safe to use in a label by replacing each run of
+// whitespace, which separates fields in the format, with an underscore.
+func label(name string) string {
+	return strings.Join(strings.Fields(name), "_")
+}
+
 func (e *Exporter) delayMicros(g *unproject.GeoGraph, l unproject.Link) int64 {
 	const SpeedOfLight = 299_792_458 // meters / sec
 	metersPerSec := SpeedOfLight / e.RefractiveIndex
 
-	n1 := g.Nodes[l.Src]
-	n2 := g.Nodes[l.Dst]
-	distKM := greatCircleDistance(n1.Lat, n1.Lon, n2.Lat, n2.Lon)
+	// Follow the drawn route if there is one
+	route := l.Route
+	if len(route) < 2 {
+		route = []unproject.LatLon{g.Nodes[l.Src].LatLon, g.Nodes[l.Dst].LatLon}
+	}
+	var distKM float64
+	for i := 1; i < len(route); i++ {
+		p, q := route[i-1], route[i]
+		distKM += greatCircleDistance(p.Lat, p.Lon, q.Lat, q.Lon)
+	}
 
 	delaySec := distKM * 1e3 / metersPerSec
 	return int64(delaySec * 1e6)
 }
 
+// makeSym adds the reverse of links until there are as many links of
+// each class from b to a as from a to b, so parallel links stay parallel.
 func makeSym(in []unproject.Link) []unproject.Link {
+	type key struct {
+		src, dst int
+		class    string
+	}
 	out := make([]unproject.Link, len(in), 2*len(in))
-	has := make(map[[2]int]bool)
+	count := make(map[key]int)
 
 	for i, l := range in {
 		out[i] = l
-		has[[2]int{l.Src, l.Dst}] = true
+		count[key{l.Src, l.Dst, l.Class}]++
 	}
 
 	for _, l := range in {
-		if !has[[2]int{l.Dst, l.Src}] {
-			has[[2]int{l.Dst, l.Src}] = true
-			out = append(out, unproject.Link{
-				Src: l.Dst,
-				Dst: l.Src,
-			})
+		rev := key{l.Dst, l.Src, l.Class}
+		if count[rev] < count[key{l.Src, l.Dst, l.Class}] {
+			count[rev]++
+			r := l
+			r.Src, r.Dst = l.Dst, l.Src
+			r.Route = nil
+			for i := len(l.Route) - 1; i >= 0; i-- {
+				r.Route = append(r.Route, l.Route[i])
+			}
+			out = append(out, r)
 		}
 	}
 	return out
@@ -0,0 +1,103 @@
+package repetita
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/uluyol/tracegeog/unproject"
+)
+
+func ll(lat, lon float64) unproject.LatLon {
+	return unproject.LatLon{Lat: lat, Lon: lon}
+}
+
+func TestMakeSym(t *testing.T) {
+	viaNorth := []unproject.LatLon{ll(0, 0), ll(5, 5), ll(0, 10)}
+	viaSouth := []unproject.LatLon{ll(0, 0), ll(-5, 5), ll(0, 10)}
+	in := []unproject.Link{
+		{Src: 0, Dst: 1, Route: viaNorth},
+		{Src: 0, Dst: 1, Route: viaSouth},
+		{Src: 1, Dst: 2},
+		{Src: 2, Dst: 1},
+	}
+	out := makeSym(in)
+
+	var back []unproject.Link
+	for _, l := range out {
+		if l.Src == 1 && l.Dst == 0 {
+			back = append(back, l)
+		}
+	}
+	if len(back) != 2 {
+		t.Fatalf("want 2 links from 1 to 0, have %v", back)
+	}
+	for i, want := range [][]unproject.LatLon{
+		{ll(0, 10), ll(5, 5), ll(0, 0)},
+		{ll(0, 10), ll(-5, 5), ll(0, 0)},
+	} {
+		if !reflect.DeepEqual(back[i].Route, want) {
+			t.Errorf("want reversed route %v, have %v", want, back[i].Route)
+		}
+	}
+	if len(out) != len(in)+2 {
+		t.Errorf("want only the links from 1 to 0 added, have %v", out)
+	}
+	if !reflect.DeepEqual(in[0].Route, viaNorth) {
+		t.Errorf("route of input link changed to %v", in[0].Route)
+	}
+}
+
+func TestWriteGeo(t *testing.T) {
+	g := &unproject.GeoGraph{
+		Nodes: []unproject.Node{
+			{LatLon: unproject.LatLon{Lat: 0, Lon: 0}},
+			{LatLon: unproject.LatLon{Lat: 0, Lon: 10}},
+		},
+		Links: []unproject.Link{
+			{Src: 0, Dst: 1, Class: "long haul", WidthPx: 1.2},
+			{Src: 0, Dst: 1, WidthPx: 3.5, Route: []unproject.LatLon{ll(0, 0), ll(10, 5), ll(0, 10)}},
+			{Src: 0, Dst: 1},
+		},
+	}
+	e := Exporter{
+		RefractiveIndex: 1.5,
+		Capacities:      CapacityTable{{WidthPx: 1, BandwidthKbps: 1000}, {WidthPx: 4, BandwidthKbps: 10000}},
+	}
+	var buf bytes.Buffer
+	if err := e.WriteGeo(g, &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	delay := func(route ...unproject.LatLon) int64 {
+		distKM := 0.0
+		for i := 1; i < len(route); i++ {
+			distKM += greatCircleDistance(route[i-1].Lat, route[i-1].Lon, route[i].Lat, route[i].Lon)
+		}
+		return int64(distKM * 1e3 / (299_792_458 / 1.5) * 1e6)
+	}
+	direct := delay(ll(0, 0), ll(0, 10))
+	routed := delay(ll(0, 0), ll(10, 5), ll(0, 10))
+	// Links between the same nodes are sorted by class.
+	wantEdges := []string{
+		fmt.Sprintf("edge_0 0 1 0 10000 %d", routed),
+		fmt.Sprintf("edge_1 0 1 0 %d %d", DefaultBandwidthKbps, direct),
+		fmt.Sprintf("long_haul_2 0 1 0 1000 %d", direct),
+	}
+
+	have := strings.Split(buf.String(), "\n")
+	i := 0
+	for i < len(have) && !strings.HasPrefix(have[i], "EDGES") {
+		i++
+	}
+	if i+2+len(wantEdges) > len(have) {
+		t.Fatalf("too few edges in output:\n%s", buf.String())
+	}
+	for k, want := range wantEdges {
+		if line := have[i+2+k]; line != want {
+			t.Errorf("want edge %q, have %q", want, line)
+		}
+	}
+}
@@ -10,11 +10,11 @@ def PlotGraph(graph, output_path):
     ax.coastlines()
 
     for l in graph["Links"]:
-        src = graph["Nodes"][l["Src"]]
-        dst = graph["Nodes"][l["Dst"]]
+        # Follow the drawn route if it was traced
+        route = l.get("Route") or [graph["Nodes"][l["Src"]], graph["Nodes"][l["Dst"]]]
         plt.plot(
-                [src["Lon"], dst["Lon"]],
-                [src["Lat"], dst["Lat"]],
+                [p["Lon"] for p in route],
+                [p["Lat"] for p in route],
                 linewidth=0.5,
                 color='orange',
                 transform=ccrs.Geodetic())
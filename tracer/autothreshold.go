@@ -0,0 +1,108 @@
+package tracer
+
+import (
+	"math"
+	"sort"
+	"strings"
+)
+
+// otsuThreshold splits scores into a low and a high group so that the
+// variance between the groups is maximized (Otsu's method) and returns
+// the midpoint of the gap between them. It returns false if scores has
+// fewer than two distinct values or if the groups are not well separated,
+// that is, if the distance between their means is less than minSeparation
+// times the sum of their standard deviations. Splitting scores that are
+// not bimodal would give an arbitrary threshold.
+func otsuThreshold(scores []float64) (float64, bool) {
+	s := append([]float64(nil), scores...)
+	sort.Float64s(s)
+
+	total := 0.0
+	for _, v := range s {
+		total += v
+	}
+
+	n := float64(len(s))
+	best := -1.0
+	bestK := 0
+	sumLow := 0.0
+	for k := 1; k < len(s); k++ {
+		sumLow += s[k-1]
+		if s[k] == s[k-1] {
+			continue
+		}
+		wl, wh := float64(k), n-float64(k)
+		ml, mh := sumLow/wl, (total-sumLow)/wh
+		if v := wl * wh * (mh - ml) * (mh - ml); v > best {
+			best = v
+			bestK = k
+		}
+	}
+	if best < 0 {
+		return 0, false
+	}
+	low, high := s[:bestK], s[bestK:]
+	if mean(high)-mean(low) < minSeparation*(stddev(low)+stddev(high)) {
+		return 0, false
+	}
+	return (s[bestK-1] + s[bestK]) / 2, true
+}
+
+// minSeparation is how far apart, in standard deviations, the groups
+// found by otsuThreshold must be.
+const minSeparation = 2
+
+func mean(s []float64) float64 {
+	sum := 0.0
+	for _, v := range s {
+		sum += v
+	}
+	return sum / float64(len(s))
+}
+
+func stddev(s []float64) float64 {
+	m := mean(s)
+	sum := 0.0
+	for _, v := range s {
+		sum += (v - m) * (v - m)
+	}
+	return math.Sqrt(sum / float64(len(s)))
+}
+
+// logHistogram logs a histogram of scores in bins of width 0.05 and
+// marks the bin containing thresh.
+func logHistogram(log func(string, ...interface{}), scores []float64, thresh float64) {
+	const (
+		binWidth = 0.05
+		barWidth = 50
+	)
+	if len(scores) == 0 {
+		return
+	}
+	bin := func(v float64) int { return int(math.Floor(v / binWidth)) }
+
+	counts := make(map[int]int)
+	minBin, maxBin := bin(scores[0]), bin(scores[0])
+	maxCount := 0
+	for _, s := range scores {
+		b := bin(s)
+		counts[b]++
+		if counts[b] > maxCount {
+			maxCount = counts[b]
+		}
+		if b < minBin {
+			minBin = b
+		}
+		if b > maxBin {
+			maxBin = b
+		}
+	}
+	for b := minBin; b <= maxBin; b++ {
+		mark := ""
+		if b == bin(thresh) {
+			mark = " <- threshold"
+		}
+		bar := strings.Repeat("#", (counts[b]*barWidth+maxCount-1)/maxCount)
+		log("  %.2f-%.2f %5d %s%s", float64(b)*binWidth, float64(b+1)*binWidth, counts[b], bar, mark)
+	}
+}
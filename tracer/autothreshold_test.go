@@ -0,0 +1,109 @@
+package tracer
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"testing"
+)
+
+func TestOtsuThreshold(t *testing.T) {
+	thresh, ok := otsuThreshold([]float64{0.95, 0.5, 0.92, 0.55, 0.9, 0.52, 0.97})
+	if !ok || math.Abs(thresh-0.725) > 1e-9 {
+		t.Errorf("want threshold 0.725, have %f (ok = %t)", thresh, ok)
+	}
+	if _, ok := otsuThreshold([]float64{0.9, 0.9}); ok {
+		t.Errorf("want no threshold for identical scores")
+	}
+	if _, ok := otsuThreshold(nil); ok {
+		t.Errorf("want no threshold for no scores")
+	}
+
+	var uniform []float64
+	for i := 0; i <= 20; i++ {
+		uniform = append(uniform, 0.5+0.02*float64(i))
+	}
+	if thresh, ok := otsuThreshold(uniform); ok {
+		t.Errorf("want no threshold for uniform scores, have %f", thresh)
+	}
+}
+
+func TestNodeTracerAutoThreshold(t *testing.T) {
+	red := color.RGBA{255, 0, 0, 255}
+	icon := image.NewRGBA(image.Rect(0, 0, 5, 5))
+	draw.Draw(icon, icon.Rect, image.NewUniform(red), image.ZP, draw.Src)
+
+	im := image.NewRGBA(image.Rect(0, 0, 60, 20))
+	draw.Draw(im, im.Rect, image.White, image.ZP, draw.Src)
+	for _, x := range []int{8, 18, 28} {
+		draw.Draw(im, image.Rect(x, 8, x+5, 13), icon, image.ZP, draw.Src)
+	}
+	// partial markers
+	for _, x := range []int{38, 48} {
+		draw.Draw(im, image.Rect(x, 8, x+3, 13), icon, image.ZP, draw.Src)
+	}
+
+	tr, err := NewNode(NodeConfig{
+		Classes: []NodeClass{{
+			Matcher:           NewIconMatcher(icon),
+			StrengthThreshold: 0.5,
+			AutoThreshold:     true,
+		}},
+		NumRunnersUp: 2,
+	}, im, t.Logf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.Find(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	g := tr.Graph()
+	if len(g.Nodes) != 3 {
+		t.Errorf("want 3 nodes, have %v", g.Nodes)
+	}
+	if len(g.RunnersUp) != 2 {
+		t.Errorf("want partial markers as runners-up, have %v", g.RunnersUp)
+	}
+}
+
+func TestNodeTracerAutoThresholdNoBreak(t *testing.T) {
+	red := color.RGBA{255, 0, 0, 255}
+	icon := image.NewRGBA(image.Rect(0, 0, 5, 5))
+	draw.Draw(icon, icon.Rect, image.NewUniform(red), image.ZP, draw.Src)
+
+	im := image.NewRGBA(image.Rect(0, 0, 40, 20))
+	draw.Draw(im, im.Rect, image.White, image.ZP, draw.Src)
+	for _, x := range []int{8, 18, 28} {
+		draw.Draw(im, image.Rect(x, 8, x+5, 13), icon, image.ZP, draw.Src)
+	}
+
+	for _, maxCount := range []int{0, 3} {
+		tr, err := NewNode(NodeConfig{
+			Classes: []NodeClass{{
+				Matcher:           NewIconMatcher(icon),
+				StrengthThreshold: 0.5,
+				MaxCount:          maxCount,
+				AutoThreshold:     true,
+			}},
+		}, im, t.Logf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = tr.Find(context.Background())
+		if maxCount == 0 {
+			if err == nil {
+				t.Errorf("want error for scores without a break and no max count, have nodes %v", tr.Graph().Nodes)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := len(tr.Graph().Nodes); n != 3 {
+			t.Errorf("max count %d: want 3 nodes, have %d", maxCount, n)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package tracer
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// ColorBlobDetector finds nodes drawn as solid blobs of a single color.
+//
+// Pixels whose color accuracy is at least MinColorAccuracy are grouped
+// into 8-connected components and every component whose area is within
+// [MinAreaPx, MaxAreaPx] is reported as a node at its centroid,
+// with the radius of a circle of the same area.
+type ColorBlobDetector struct {
+	Color            color.Color
+	MinColorAccuracy float64 // RGB value match, 0-1, 1 is an exact match
+	MinAreaPx        int
+	MaxAreaPx        int // 0 means no limit
+}
+
+var _ BlobDetector = &ColorBlobDetector{}
+
+func (d *ColorBlobDetector) Detect(im *image.RGBA) []Blob {
+	b := im.Bounds()
+	target := toRGBA(d.Color)
+
+	accuracy := make([]float64, b.Dx()*b.Dy())
+	mask := newBitmap2(b.Dx(), b.Dy())
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := im.RGBAAt(x, y)
+			if c.A == 0 {
+				continue
+			}
+			acc := 1 - colorDist(target, c)
+			if acc >= d.MinColorAccuracy {
+				i, j := x-b.Min.X, y-b.Min.Y
+				accuracy[i+j*b.Dx()] = acc
+				mask.Set(i, j)
+			}
+		}
+	}
+
+	var blobs []Blob
+	for _, comp := range connectedComponents(mask, b.Dx(), b.Dy()) {
+		if len(comp) < d.MinAreaPx || (d.MaxAreaPx > 0 && len(comp) > d.MaxAreaPx) {
+			continue
+		}
+		var sumX, sumY, sumAcc float64
+		for _, p := range comp {
+			sumX += float64(p.X)
+			sumY += float64(p.Y)
+			sumAcc += accuracy[p.X+p.Y*b.Dx()]
+		}
+		n := float64(len(comp))
+		blobs = append(blobs, Blob{
+			X:      sumX/n + float64(b.Min.X),
+			Y:      sumY/n + float64(b.Min.Y),
+			Radius: math.Sqrt(n / math.Pi),
+			Score:  sumAcc / n,
+		})
+	}
+	return blobs
+}
+
+// connectedComponents returns the 8-connected components of the set
+// pixels in mask, which has dimensions w x h.
+func connectedComponents(mask *bitmap2, w, h int) [][]image.Point {
+	seen := newBitmap2(w, h)
+	var comps [][]image.Point
+	var stack []image.Point
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if !mask.Get(x, y) || seen.Get(x, y) {
+				continue
+			}
+			var comp []image.Point
+			seen.Set(x, y)
+			stack = append(stack[:0], image.Pt(x, y))
+			for len(stack) > 0 {
+				p := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				comp = append(comp, p)
+				for dy := -1; dy <= 1; dy++ {
+					for dx := -1; dx <= 1; dx++ {
+						q := image.Pt(p.X+dx, p.Y+dy)
+						if q.X < 0 || q.Y < 0 || q.X >= w || q.Y >= h {
+							continue
+						}
+						if mask.Get(q.X, q.Y) && !seen.Get(q.X, q.Y) {
+							seen.Set(q.X, q.Y)
+							stack = append(stack, q)
+						}
+					}
+				}
+			}
+			comps = append(comps, comp)
+		}
+	}
+	return comps
+}
+
+func toRGBA(c color.Color) color.RGBA {
+	r, g, b, a := c.RGBA()
+	return color.RGBA{
+		R: uint8(r >> 8), G: uint8(g >> 8), B: uint8(b >> 8), A: uint8(a >> 8),
+	}
+}
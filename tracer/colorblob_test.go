@@ -0,0 +1,53 @@
+package tracer
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+func TestColorBlobDetector(t *testing.T) {
+	red := color.RGBA{255, 0, 0, 255}
+	im := image.NewRGBA(image.Rect(0, 0, 40, 30))
+	draw.Draw(im, im.Rect, image.White, image.ZP, draw.Src)
+
+	fill := func(r image.Rectangle, c color.Color) {
+		draw.Draw(im, r, image.NewUniform(c), image.ZP, draw.Src)
+	}
+	fill(image.Rect(2, 3, 7, 8), red)                             // node at (4, 5)
+	fill(image.Rect(20, 20, 25, 25), color.RGBA{240, 10, 0, 255}) // node at (22, 22)
+	fill(image.Rect(30, 5, 31, 6), red)                           // too small
+	fill(image.Rect(10, 10, 15, 15), color.Black)                 // wrong color
+
+	tr, err := NewNode(NodeConfig{Classes: []NodeClass{{
+		Detector: &ColorBlobDetector{
+			Color:            red,
+			MinColorAccuracy: 0.9,
+			MinAreaPx:        4,
+		},
+		StrengthThreshold: 0.9,
+		MaxCount:          10,
+	}}}, im, t.Logf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.Find(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []image.Point{{4, 5}, {22, 22}}
+	have := tr.Graph().Nodes
+	if len(have) != len(want) {
+		t.Fatalf("want nodes %v, have %v", want, have)
+	}
+	for i := range want {
+		if have[i].Pt() != want[i] {
+			t.Errorf("node %d: want %v, have %v", i, want[i], have[i])
+		}
+		if r := have[i].Radius; r < 2.5 || r > 3 {
+			t.Errorf("node %d: bad radius %f", i, r)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package tracer
+
+import (
+	"context"
+	"image"
+	"math"
+)
+
+// Two arms of a crossing belong to the same line if they point at most
+// this far from opposite directions.
+const maxCrossingBendDeg = 15
+
+// Lines that cross at shallower angles than this are too hard to tell
+// apart, so they are not treated as crossings.
+const minCrossingAngleDeg = 20
+
+// A crossing is where two lines cross without a node.
+type crossing struct {
+	center image.Point
+	radius float64
+	arms   [4]vec2 // unit vectors out of the center, in clockwise order
+}
+
+func (c *crossing) contains(p image.Point) bool {
+	return math.Hypot(float64(p.X-c.center.X), float64(p.Y-c.center.Y)) <= c.radius
+}
+
+// exit returns the arm across from the one closest to from, which is
+// where a run that enters c at from goes straight through to.
+func (c *crossing) exit(from image.Point) vec2 {
+	dx, dy := float64(from.X-c.center.X), float64(from.Y-c.center.Y)
+	entry := 0
+	bestDot := math.Inf(-1)
+	for i, a := range c.arms {
+		if d := a.X*dx + a.Y*dy; d > bestDot {
+			entry = i
+			bestDot = d
+		}
+	}
+	return c.arms[(entry+2)%4]
+}
+
+// onLineOf reports whether pt is within halfWidth of the line through c
+// that leaves it in direction exit or closer to it than to the other
+// line. Lines join the points where their arms leave c, since the center
+// of c may be a little off of them.
+func (c *crossing) onLineOf(exit vec2, pt image.Point, halfWidth float64) bool {
+	dx, dy := float64(pt.X-c.center.X), float64(pt.Y-c.center.Y)
+	dist := func(i int) float64 {
+		a, b := c.arms[i], c.arms[i+2]
+		dir := vec2{a.X - b.X, a.Y - b.Y}
+		ax, ay := dx-c.radius*a.X, dy-c.radius*a.Y
+		return math.Abs(ax*dir.Y-ay*dir.X) / math.Hypot(dir.X, dir.Y)
+	}
+	own, other := dist(0), dist(1)
+	if exit == c.arms[1] || exit == c.arms[3] {
+		own, other = other, own
+	}
+	return own <= halfWidth || own <= other
+}
+
+// circleArms returns the directions of the arcs of the circle of radius
+// r around (x, y) that are on lines.
+func circleArms(onLine *bitmap2, w, h, x, y int, r float64) []vec2 {
+	n := int(math.Ceil(2 * math.Pi * r))
+	on := make([]bool, n)
+	for k := range on {
+		a := 2 * math.Pi * float64(k) / float64(n)
+		i := x + int(math.Round(r*math.Cos(a)))
+		j := y + int(math.Round(r*math.Sin(a)))
+		on[k] = i >= 0 && j >= 0 && i < w && j < h && onLine.Get(i, j)
+	}
+
+	// Start at the beginning of an arc so that none wrap around.
+	start := -1
+	for k := range on {
+		if on[k] && !on[(k+n-1)%n] {
+			start = k
+			break
+		}
+	}
+	if start < 0 {
+		return nil
+	}
+	var arms []vec2
+	for k := 0; k < n; {
+		if !on[(start+k)%n] {
+			k++
+			continue
+		}
+		end := k
+		for end < n && on[(start+end)%n] {
+			end++
+		}
+		a := 2 * math.Pi * (float64(start) + float64(k+end-1)/2) / float64(n)
+		arms = append(arms, vec2{math.Cos(a), math.Sin(a)})
+		k = end
+	}
+	return arms
+}
+
+// isCrossing reports whether arms are two straight lines that cross.
+//
+// Two lines that run side by side also have four arms, but they bend by
+// as much as the angle between them, so require the bend to be small
+// compared to the angle.
+func isCrossing(arms []vec2) bool {
+	if len(arms) != 4 {
+		return false
+	}
+	bend := 0.0
+	angle := math.Pi
+	for i, a := range arms {
+		if i < 2 {
+			bend = math.Max(bend, offAngle(a, vec2{-arms[i+2].X, -arms[i+2].Y}))
+		}
+		angle = math.Min(angle, offAngle(a, arms[(i+1)%4]))
+	}
+	return bend <= maxCrossingBendDeg*math.Pi/180 &&
+		angle >= minCrossingAngleDeg*math.Pi/180 &&
+		bend <= angle/3
+}
+
+// findCrossings finds the crossings among the line pixels pts, whose
+// coordinates are relative to onLine. A pixel is part of a crossing if
+// the circle of radius r around it passes over four lines that pair up
+// into two nearly straight ones. Pixels where nearNode, indexed row by
+// row, is not -1 are ignored, since lines meet at nodes anyway.
+func findCrossings(ctx context.Context, onLine *bitmap2, w, h int, pts []image.Point, r float64, nearNode []int32, stage string, progress ProgressFunc) ([]crossing, error) {
+	rows := make([][]int, h)
+	err := forEach(ctx, h, stage, progress, func(y int) {
+		for x := 0; x < w; x++ {
+			if onLine.Get(x, y) && nearNode[y*w+x] < 0 &&
+				isCrossing(circleArms(onLine, w, h, x, y, r)) {
+				rows[y] = append(rows[y], x)
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	isCross := newBitmap2(w, h)
+	for y, xs := range rows {
+		for _, x := range xs {
+			isCross.Set(x, y)
+		}
+	}
+
+	// Neighboring pixels belong to the same crossing, whose center is
+	// the pixel closest to their centroid.
+	seen := newBitmap2(w, h)
+	var crossings []crossing
+	for _, p := range pts {
+		if !isCross.Get(p.X, p.Y) || seen.Get(p.X, p.Y) {
+			continue
+		}
+		var group []image.Point
+		var sum vec2
+		seen.Set(p.X, p.Y)
+		stack := []image.Point{p}
+		for len(stack) > 0 {
+			q := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			group = append(group, q)
+			sum.X += float64(q.X)
+			sum.Y += float64(q.Y)
+			for _, d := range skelNeighbors {
+				s := q.Add(d)
+				if s.X >= 0 && s.Y >= 0 && s.X < w && s.Y < h && isCross.Get(s.X, s.Y) && !seen.Get(s.X, s.Y) {
+					seen.Set(s.X, s.Y)
+					stack = append(stack, s)
+				}
+			}
+		}
+		cx, cy := sum.X/float64(len(group)), sum.Y/float64(len(group))
+		center := group[0]
+		for _, q := range group {
+			if math.Hypot(float64(q.X)-cx, float64(q.Y)-cy) < math.Hypot(float64(center.X)-cx, float64(center.Y)-cy) {
+				center = q
+			}
+		}
+		c := crossing{center: center, radius: r}
+		copy(c.arms[:], circleArms(onLine, w, h, center.X, center.Y, r))
+		crossings = append(crossings, c)
+	}
+	return crossings, nil
+}
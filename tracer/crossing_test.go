@@ -0,0 +1,156 @@
+package tracer
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"math"
+	"sort"
+	"testing"
+)
+
+func lineBitmap(im image.Image) (*bitmap2, []image.Point) {
+	b := im.Bounds()
+	onLine := newBitmap2(b.Dx(), b.Dy())
+	var pts []image.Point
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if r, _, _, _ := im.At(x, y).RGBA(); r < 0x8000 {
+				onLine.Set(x, y)
+				pts = append(pts, image.Pt(x, y))
+			}
+		}
+	}
+	return onLine, pts
+}
+
+func TestFindCrossings(t *testing.T) {
+	dc := newLinkCanvas(200, 200)
+	dc.SetRGB(0, 0, 0)
+	dc.SetLineWidth(2)
+	// an X crossing at (60, 60)
+	dc.DrawLine(20, 30, 100, 90)
+	dc.DrawLine(20, 100, 100, 20)
+	// a T at (150, 60)
+	dc.DrawLine(120, 60, 180, 60)
+	dc.DrawLine(150, 60, 150, 100)
+	// a Y at (60, 160)
+	dc.DrawLine(20, 160, 60, 160)
+	dc.DrawLine(60, 160, 100, 130)
+	dc.DrawLine(60, 160, 100, 190)
+	// a node where two lines cross at (150, 150)
+	dc.DrawLine(120, 120, 180, 180)
+	dc.DrawLine(120, 180, 180, 120)
+	dc.Stroke()
+
+	onLine, pts := lineBitmap(dc.Image())
+	nearNode := nodeRegions([]Node{{X: 150, Y: 150}}, image.Rect(0, 0, 200, 200), 5+8)
+	cs, err := findCrossings(context.Background(), onLine, 200, 200, pts, 8, nearNode, "finding crossings", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cs) != 1 {
+		t.Fatalf("want 1 crossing, have %d: %v", len(cs), cs)
+	}
+	c := cs[0]
+	if math.Hypot(float64(c.center.X-60), float64(c.center.Y-60)) > 2 {
+		t.Errorf("want crossing at (60, 60), have %v", c.center)
+	}
+
+	// A run that enters from (20, 30) leaves towards (100, 90).
+	exit := c.exit(image.Pt(40, 45))
+	if offAngle(exit, vec2{80, 60}) > 10*math.Pi/180 {
+		t.Errorf("want exit towards (100, 90), have %v", exit)
+	}
+}
+
+func TestLNNTrackerCrossing(t *testing.T) {
+	p := func(x, y int) image.Point { return image.Pt(x, y) }
+
+	// A horizontal line from the node at (0, 50) crosses a diagonal one
+	// at (30, 50) and has a small gap right after.
+	var pts []image.Point
+	for x := 0; x <= 60; x++ {
+		if x < 31 || x > 33 {
+			pts = append(pts, p(x, 50))
+		}
+	}
+	for d := -20; d <= 20; d++ {
+		if d != 0 {
+			pts = append(pts, p(30+d, 50+d))
+		}
+	}
+	dist := func(p1, p2 image.Point) float64 {
+		return math.Hypot(float64(p1.X-p2.X), float64(p1.Y-p2.Y))
+	}
+	sort.SliceStable(pts, func(i, j int) bool { return dist(pts[i], p(0, 50)) < dist(pts[j], p(0, 50)) })
+
+	newTracker := func(crossings []crossing) *lnnTracker {
+		return &lnnTracker{
+			AllowedGapPx:          4,
+			AllowedAngleOffsetRad: 60 * math.Pi / 180,
+			EWMAPointThresh:       4,
+			DistPx:                dist,
+			Crossings:             crossings,
+			CrossingHalfWidthPx:   1,
+		}
+	}
+	run := func(tracker *lnnTracker) lineRun {
+		tracker.AddPoint(pts[0], 0)
+		for _, pt := range pts[1:] {
+			tracker.AddPoint(pt, int(dist(pt, p(0, 50))/2)+1)
+		}
+		return tracker.Runs()[0]
+	}
+
+	// Without knowing about the crossing, the run turns onto the
+	// diagonal line.
+	if r := run(newTracker(nil)); r.Dst() == p(60, 50) {
+		t.Errorf("want run to turn at crossing, have %v", r.SeenPoints)
+	}
+
+	c := crossing{center: p(30, 50), radius: 5}
+	c.arms = [4]vec2{{1, 0}, {math.Sqrt2 / 2, math.Sqrt2 / 2}, {-1, 0}, {-math.Sqrt2 / 2, -math.Sqrt2 / 2}}
+	r := run(newTracker([]crossing{c}))
+	if r.Dst() != p(60, 50) {
+		t.Errorf("want run to end at (60, 50), have %v", r.SeenPoints)
+	}
+	for _, pt := range r.SeenPoints {
+		if pt.Y != 50 {
+			t.Errorf("want run to go straight through crossing, have %v", r.SeenPoints)
+			break
+		}
+	}
+}
+
+func TestLineRunsCrossing(t *testing.T) {
+	// Two lines cross at a shallow angle, so a run cannot tell them
+	// apart by direction alone.
+	dc := newLinkCanvas(600, 200)
+	dc.SetRGB(0, 0, 0)
+	dc.SetLineWidth(3)
+	dc.DrawLine(20, 65, 280, 135)
+	dc.DrawLine(20, 135, 280, 65)
+	dc.Stroke()
+
+	g := XYGraph{Nodes: []Node{
+		{X: 20, Y: 65},   // A
+		{X: 20, Y: 135},  // B
+		{X: 280, Y: 65},  // C
+		{X: 280, Y: 135}, // D
+	}}
+	tr := NewLink(LinkConfig{
+		Color:                color.Black,
+		MinColorAccuracy:     0.6,
+		MinWidthPx:           1,
+		AllowedGapPx:         3,
+		NodeProximityPx:      4,
+		ExpectedDirectionDeg: 60,
+		NarrowRunSteps:       true,
+	}, dc.Image(), &g, t.Logf)
+	if err := tr.Find(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	checkLinks(t, "crossing", tr.Graph().Links, [2]int{0, 3}, [2]int{1, 2})
+}
@@ -0,0 +1,298 @@
+package tracer
+
+import (
+	"image"
+	"math"
+	"sort"
+)
+
+// Neighboring dashes whose periods (the distance between their centers)
+// differ by at most this fraction belong to the same dashed line.
+const dashPeriodTolerance = 0.3
+
+// Links with at least this fraction of their points in bridged gaps are
+// dashed.
+const dashedLinkFrac = 0.15
+
+// A dash is a connected piece of line, which may be one of the dashes of
+// a dashed line.
+type dash struct {
+	center    vec2
+	axis      vec2 // unit vector along the dash
+	halfLen   float64
+	halfWidth float64
+
+	next [2]int // closest dash in line with this one past either end, or -1
+}
+
+// end returns the end of d on side s (0 or 1).
+func (d *dash) end(s int) vec2 {
+	sign := float64(2*s - 1)
+	return vec2{d.center.X + sign*d.halfLen*d.axis.X, d.center.Y + sign*d.halfLen*d.axis.Y}
+}
+
+// round reports whether d is too short to tell its direction, like the
+// dots of a dotted line.
+func (d *dash) round() bool { return d.halfLen < 1.5*d.halfWidth }
+
+// findDashes measures the 8-connected components of the set pixels in
+// mask, which has dimensions w x h.
+func findDashes(mask *bitmap2, w, h int) []dash {
+	comps := connectedComponents(mask, w, h)
+	dashes := make([]dash, len(comps))
+	for i, comp := range comps {
+		var mx, my float64
+		for _, p := range comp {
+			mx += float64(p.X)
+			my += float64(p.Y)
+		}
+		n := float64(len(comp))
+		mx, my = mx/n, my/n
+		var cxx, cxy, cyy float64
+		for _, p := range comp {
+			dx, dy := float64(p.X)-mx, float64(p.Y)-my
+			cxx += dx * dx
+			cxy += dx * dy
+			cyy += dy * dy
+		}
+		theta := math.Atan2(2*cxy, cxx-cyy) / 2
+		axis := vec2{math.Cos(theta), math.Sin(theta)}
+
+		// Extent along and across the axis
+		minA, maxA := math.Inf(1), math.Inf(-1)
+		maxC := 0.0
+		for _, p := range comp {
+			dx, dy := float64(p.X)-mx, float64(p.Y)-my
+			a := dx*axis.X + dy*axis.Y
+			minA = math.Min(minA, a)
+			maxA = math.Max(maxA, a)
+			maxC = math.Max(maxC, math.Abs(dx*axis.Y-dy*axis.X))
+		}
+		mid := (minA + maxA) / 2
+		dashes[i] = dash{
+			center:    vec2{mx + mid*axis.X, my + mid*axis.Y},
+			axis:      axis,
+			halfLen:   (maxA-minA)/2 + 0.5,
+			halfWidth: maxC + 0.5,
+			next:      [2]int{-1, -1},
+		}
+	}
+	return dashes
+}
+
+// A dashGrid finds the dashes near a point without looking at all of
+// them. Each dash is in the cells that the square of radius halfLen
+// around its center covers.
+type dashGrid struct {
+	cellPx float64
+	cells  map[image.Point][]int
+	seen   []int // stamp of the last query that returned each dash
+	stamp  int
+}
+
+func newDashGrid(dashes []dash, cellPx float64) *dashGrid {
+	g := &dashGrid{
+		cellPx: cellPx,
+		cells:  make(map[image.Point][]int),
+		seen:   make([]int, len(dashes)),
+	}
+	for i, d := range dashes {
+		g.forCells(d.center, d.halfLen, func(k image.Point) {
+			g.cells[k] = append(g.cells[k], i)
+		})
+	}
+	return g
+}
+
+func (g *dashGrid) forCells(c vec2, r float64, fn func(image.Point)) {
+	x0, x1 := int(math.Floor((c.X-r)/g.cellPx)), int(math.Floor((c.X+r)/g.cellPx))
+	y0, y1 := int(math.Floor((c.Y-r)/g.cellPx)), int(math.Floor((c.Y+r)/g.cellPx))
+	for y := y0; y <= y1; y++ {
+		for x := x0; x <= x1; x++ {
+			fn(image.Pt(x, y))
+		}
+	}
+}
+
+// near returns, in increasing order, the dashes whose square may overlap
+// the square of radius r around c.
+func (g *dashGrid) near(c vec2, r float64) []int {
+	g.stamp++
+	var out []int
+	g.forCells(c, r, func(k image.Point) {
+		for _, j := range g.cells[k] {
+			if g.seen[j] != g.stamp {
+				g.seen[j] = g.stamp
+				out = append(out, j)
+			}
+		}
+	})
+	sort.Ints(out)
+	return out
+}
+
+// linkDashes sets the next dash past each end of each dash. Dashes are
+// only linked if each is the other's closest and the gap between them
+// is at most maxGapPx and runs along both within maxTurnRad.
+func linkDashes(dashes []dash, maxGapPx, maxTurnRad float64) {
+	// Dashes that are linked have centers at most their halfLens plus
+	// maxGapPx apart.
+	grid := newDashGrid(dashes, math.Max(maxGapPx, 4))
+
+	// Point round dashes at the closest dash, which is the next one
+	// along a dotted line.
+	for i := range dashes {
+		c := &dashes[i]
+		if !c.round() {
+			continue
+		}
+		minDist := math.Inf(1)
+		for _, j := range grid.near(c.center, c.halfLen+maxGapPx) {
+			d := &dashes[j]
+			dist := math.Hypot(d.center.X-c.center.X, d.center.Y-c.center.Y)
+			if j != i && dist < minDist && dist-c.halfLen-d.halfLen <= maxGapPx {
+				minDist = dist
+				c.axis = vec2{(d.center.X - c.center.X) / dist, (d.center.Y - c.center.Y) / dist}
+			}
+		}
+	}
+
+	inLine := func(d *dash, v vec2) bool {
+		a := offAngle(d.axis, v)
+		return math.Min(a, math.Pi-a) <= maxTurnRad
+	}
+
+	// Closest candidate past each end, then keep mutual ones.
+	type cand struct {
+		d, s int // dash and its end that faces this one
+		gap  float64
+	}
+	best := make([][2]cand, len(dashes))
+	for i := range dashes {
+		c := &dashes[i]
+		near := grid.near(c.center, c.halfLen+maxGapPx)
+		for s := 0; s < 2; s++ {
+			best[i][s] = cand{d: -1, gap: math.Inf(1)}
+			e := c.end(s)
+			out := vec2{e.X - c.center.X, e.Y - c.center.Y}
+			for _, j := range near {
+				if j == i {
+					continue
+				}
+				d := &dashes[j]
+				between := vec2{d.center.X - c.center.X, d.center.Y - c.center.Y}
+				if between.X*out.X+between.Y*out.Y <= 0 {
+					continue // behind this end
+				}
+				if !inLine(c, between) || !inLine(d, between) {
+					continue
+				}
+				for t := 0; t < 2; t++ {
+					f := d.end(t)
+					gap := math.Hypot(f.X-e.X, f.Y-e.Y)
+					if gap <= maxGapPx && gap < best[i][s].gap {
+						best[i][s] = cand{j, t, gap}
+					}
+				}
+			}
+		}
+	}
+	for i := range dashes {
+		for s := 0; s < 2; s++ {
+			b := best[i][s]
+			if b.d >= 0 && best[b.d][b.s].d == i && best[b.d][b.s].s == s {
+				dashes[i].next[s] = b.d
+			}
+		}
+	}
+}
+
+// dashPeriod returns the distance between the centers of dashes i and j.
+func dashPeriod(dashes []dash, i, j int) float64 {
+	a, b := dashes[i].center, dashes[j].center
+	return math.Hypot(a.X-b.X, a.Y-b.Y)
+}
+
+// periodic reports whether the gap past end s of dash i is in a run of
+// evenly spaced dashes, that is, whether the period to the next dash
+// matches the period on either side of it.
+func periodic(dashes []dash, i, s int) bool {
+	j := dashes[i].next[s]
+	p := dashPeriod(dashes, i, j)
+	matches := func(k, l int) bool {
+		if l < 0 || l == i || l == j {
+			return false
+		}
+		q := dashPeriod(dashes, k, l)
+		return math.Abs(p-q) <= math.Max(2, dashPeriodTolerance*math.Max(p, q))
+	}
+	if matches(i, dashes[i].next[1-s]) {
+		return true
+	}
+	for t := 0; t < 2; t++ {
+		if dashes[j].next[t] != i && matches(j, dashes[j].next[t]) {
+			return true
+		}
+	}
+	return false
+}
+
+// bridgeDashes fills the gaps between evenly spaced dashes among the set
+// pixels in onLine, which has dimensions w x h, as long as the gaps are
+// at most maxGapPx and run along the dashes within maxTurnRad. It returns
+// the pixels that it filled.
+func bridgeDashes(onLine *bitmap2, w, h int, maxGapPx, maxTurnRad float64) *bitmap2 {
+	dashes := findDashes(onLine, w, h)
+	linkDashes(dashes, maxGapPx, maxTurnRad)
+
+	bridged := newBitmap2(w, h)
+	fill := func(x, y int) {
+		if x >= 0 && y >= 0 && x < w && y < h && !onLine.Get(x, y) && !bridged.Get(x, y) {
+			bridged.Set(x, y)
+		}
+	}
+	for i := range dashes {
+		for s := 0; s < 2; s++ {
+			j := dashes[i].next[s]
+			if j < i || !periodic(dashes, i, s) {
+				continue // each gap is seen from both sides
+			}
+			e := dashes[i].end(s)
+			f := dashes[j].end(0)
+			if g := dashes[j].end(1); math.Hypot(g.X-e.X, g.Y-e.Y) < math.Hypot(f.X-e.X, f.Y-e.Y) {
+				f = g
+			}
+
+			// Draw a stroke as wide as the dashes across the gap
+			r := math.Min(dashes[i].halfWidth, dashes[j].halfWidth)
+			steps := int(math.Ceil(2*math.Hypot(f.X-e.X, f.Y-e.Y))) + 1
+			for k := 0; k <= steps; k++ {
+				t := float64(k) / float64(steps)
+				cx, cy := e.X+t*(f.X-e.X), e.Y+t*(f.Y-e.Y)
+				for y := int(math.Floor(cy - r)); y <= int(math.Ceil(cy+r)); y++ {
+					for x := int(math.Floor(cx - r)); x <= int(math.Ceil(cx+r)); x++ {
+						if math.Hypot(float64(x)-cx, float64(y)-cy) < r {
+							fill(x, y)
+						}
+					}
+				}
+			}
+		}
+	}
+	return bridged
+}
+
+// isDashed reports whether enough of points are in bridged, whose origin
+// is at b.Min.
+func isDashed(points []image.Point, bridged *bitmap2, b image.Rectangle) bool {
+	if bridged == nil || len(points) == 0 {
+		return false
+	}
+	n := 0
+	for _, p := range points {
+		if p.In(b) && bridged.Get(p.X-b.Min.X, p.Y-b.Min.Y) {
+			n++
+		}
+	}
+	return float64(n) >= dashedLinkFrac*float64(len(points))
+}
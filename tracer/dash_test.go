@@ -0,0 +1,119 @@
+package tracer
+
+import (
+	"context"
+	"fmt"
+	"image/color"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestDashedLinks(t *testing.T) {
+	dc := newLinkCanvas(500, 240)
+	dc.SetRGB(0, 0, 0)
+	dc.SetLineWidth(3)
+	// dashed line from A to B
+	dc.SetDash(8, 6)
+	dc.DrawLine(20, 40, 220, 40)
+	dc.Stroke()
+	// dotted line from C to D
+	for x := 20.0; x <= 220; x += 8 {
+		dc.DrawCircle(x, 120, 2)
+	}
+	dc.Fill()
+	// solid line from E to F with a single gap that is not bridged
+	dc.SetDash()
+	dc.DrawLine(20, 200, 110, 200)
+	dc.DrawLine(118, 200, 220, 200)
+	dc.Stroke()
+
+	g := XYGraph{Nodes: []Node{
+		{X: 20, Y: 40},   // A
+		{X: 220, Y: 40},  // B
+		{X: 20, Y: 120},  // C
+		{X: 220, Y: 120}, // D
+		{X: 20, Y: 200},  // E
+		{X: 220, Y: 200}, // F
+	}}
+
+	for _, m := range []LinkMethod{LineRuns, PathSearch, Skeleton} {
+		tr := NewLink(LinkConfig{
+			Method:               m,
+			Color:                color.Black,
+			MinColorAccuracy:     0.6,
+			MinWidthPx:           1,
+			AllowedGapPx:         3,
+			NodeProximityPx:      6,
+			ExpectedDirectionDeg: 30,
+			MaxDashGapPx:         10,
+			MaxLinkLenPx:         250,
+			OffLineCost:          10,
+			MaxPathCost:          2,
+		}, dc.Image(), &g, t.Logf)
+		if err := tr.Find(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		have := checkLinks(t, fmt.Sprintf("method %d", m), tr.Graph().Links, [2]int{0, 1}, [2]int{2, 3})
+		for k, ls := range have {
+			for _, l := range ls {
+				if !l.Dashed {
+					t.Errorf("method %d: want link %v to be dashed", m, k)
+				}
+			}
+		}
+	}
+}
+
+func TestLinkDiagonalDashes(t *testing.T) {
+	// Dashes along a diagonal, each pointing straight at the next.
+	theta := math.Atan2(5, 3)
+	axis := vec2{math.Cos(theta), math.Sin(theta)}
+	var dashes []dash
+	for k := 0; k < 40; k++ {
+		dashes = append(dashes, dash{
+			center:    vec2{float64(20 + 3*k), float64(20 + 5*k)},
+			axis:      axis,
+			halfLen:   2,
+			halfWidth: 1,
+			next:      [2]int{-1, -1},
+		})
+	}
+
+	linkDashes(dashes, 10, math.Pi/6)
+	for i := range dashes {
+		want := [2]int{i - 1, i + 1}
+		if i == len(dashes)-1 {
+			want[1] = -1
+		}
+		if dashes[i].next != want {
+			t.Errorf("dash %d: want next %v, have %v", i, want, dashes[i].next)
+		}
+	}
+}
+
+func TestDashGridNear(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	dashes := make([]dash, 200)
+	for i := range dashes {
+		dashes[i] = dash{
+			center:  vec2{rng.Float64() * 300, rng.Float64() * 300},
+			halfLen: rng.Float64() * 40,
+		}
+	}
+	g := newDashGrid(dashes, 10)
+	for i, c := range dashes {
+		r := c.halfLen + 10
+		near := make(map[int]bool)
+		for _, j := range g.near(c.center, r) {
+			near[j] = true
+		}
+		for j, d := range dashes {
+			reach := r + d.halfLen
+			if math.Abs(d.center.X-c.center.X) <= reach && math.Abs(d.center.Y-c.center.Y) <= reach && !near[j] {
+				t.Errorf("dash %d: missing nearby dash %d", i, j)
+			}
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package tracer
+
+import "math"
+
+// A LinkScore compares traced links against reference links.
+type LinkScore struct {
+	Want, Have, Match int
+}
+
+// Precision returns the fraction of traced links that are in the reference.
+func (s LinkScore) Precision() float64 {
+	if s.Have == 0 {
+		return 0
+	}
+	return float64(s.Match) / float64(s.Have)
+}
+
+// Recall returns the fraction of reference links that were traced.
+func (s LinkScore) Recall() float64 {
+	if s.Want == 0 {
+		return 0
+	}
+	return float64(s.Match) / float64(s.Want)
+}
+
+// CompareLinks scores the links of have against those of want.
+//
+// The graphs need not share node indices: each node of have stands for
+// the closest node of want that is at most maxDistPx away, so that links
+// traced on a graph whose nodes were later moved by hand still match.
+// Links are undirected and counted once.
+func CompareLinks(want, have *XYGraph, maxDistPx float64) LinkScore {
+	type key struct{ a, b int }
+	linkSet := func(g *XYGraph, nodeIdx func(int) int) map[key]bool {
+		s := make(map[key]bool)
+		for _, l := range g.Links {
+			a, b := nodeIdx(l.Src), nodeIdx(l.Dst)
+			if a == b {
+				continue
+			}
+			if a > b {
+				a, b = b, a
+			}
+			s[key{a, b}] = true
+		}
+		return s
+	}
+
+	wantLinks := linkSet(want, func(i int) int { return i })
+	haveLinks := linkSet(have, func(i int) int {
+		n := have.Nodes[i]
+		best, bestDist := -1-i, maxDistPx // unmatched nodes stay distinct
+		for j, m := range want.Nodes {
+			if d := math.Hypot(n.X-m.X, n.Y-m.Y); d <= bestDist {
+				best, bestDist = j, d
+			}
+		}
+		return best
+	})
+
+	s := LinkScore{Want: len(wantLinks), Have: len(haveLinks)}
+	for k := range haveLinks {
+		if wantLinks[k] {
+			s.Match++
+		}
+	}
+	return s
+}
@@ -0,0 +1,27 @@
+package tracer
+
+import "testing"
+
+func TestCompareLinks(t *testing.T) {
+	want := &XYGraph{
+		Nodes: []Node{{X: 0, Y: 0}, {X: 100, Y: 0}, {X: 100, Y: 100}, {X: 0, Y: 100}},
+		Links: []Link{{Src: 0, Dst: 1}, {Src: 1, Dst: 2}, {Src: 2, Dst: 3}, {Src: 3, Dst: 0}},
+	}
+	have := &XYGraph{
+		Nodes: []Node{{X: 101, Y: 99}, {X: 2, Y: 1}, {X: 99, Y: 2}, {X: 50, Y: 50}},
+		Links: []Link{
+			{Src: 1, Dst: 2}, // 0-1
+			{Src: 0, Dst: 2}, // 2-1, reversed
+			{Src: 2, Dst: 0}, // duplicate
+			{Src: 1, Dst: 0}, // 0-2, not in want
+			{Src: 3, Dst: 1}, // unmatched node
+		},
+	}
+	s := CompareLinks(want, have, 5)
+	if s != (LinkScore{Want: 4, Have: 4, Match: 2}) {
+		t.Errorf("got %+v", s)
+	}
+	if p, r := s.Precision(), s.Recall(); p != 0.5 || r != 0.5 {
+		t.Errorf("got precision %f recall %f", p, r)
+	}
+}
@@ -0,0 +1,101 @@
+package tracer
+
+import (
+	"fmt"
+	"image"
+)
+
+// ExtractIcon cuts the node marker containing seed out of im, for use
+// with NewIconMatcher.
+//
+// The marker is the 4-connected region of pixels whose color accuracy
+// relative to the seed pixel is at least minColorAccuracy, along with
+// any holes that it encloses. The icon is cropped to the marker and
+// pixels outside of it are transparent. It is an error for the marker
+// to be wider or taller than maxSizePx.
+func ExtractIcon(im image.Image, seed image.Point, minColorAccuracy float64, maxSizePx int) (*image.RGBA, error) {
+	b := im.Bounds()
+	if !seed.In(b) {
+		return nil, fmt.Errorf("seed %v is outside of image %v", seed, b)
+	}
+	seedColor := toRGBA(im.At(seed.X, seed.Y))
+
+	w, h := b.Dx(), b.Dy()
+	region := newBitmap2(w, h)
+	region.Set(seed.X-b.Min.X, seed.Y-b.Min.Y)
+	bounds := image.Rectangle{seed, seed.Add(image.Pt(1, 1))}
+	stack := []image.Point{seed}
+	for len(stack) > 0 {
+		p := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for _, d := range [...]image.Point{{1, 0}, {-1, 0}, {0, 1}, {0, -1}} {
+			q := p.Add(d)
+			if !q.In(b) || region.Get(q.X-b.Min.X, q.Y-b.Min.Y) {
+				continue
+			}
+			if 1-colorDist(seedColor, toRGBA(im.At(q.X, q.Y))) < minColorAccuracy {
+				continue
+			}
+			region.Set(q.X-b.Min.X, q.Y-b.Min.Y)
+			bounds = bounds.Union(image.Rectangle{q, q.Add(image.Pt(1, 1))})
+			if bounds.Dx() > maxSizePx || bounds.Dy() > maxSizePx {
+				return nil, fmt.Errorf("marker at %v is larger than %d pixels", seed, maxSizePx)
+			}
+			stack = append(stack, q)
+		}
+	}
+
+	// Pixels that cannot reach the edge of bounds without crossing the
+	// region are holes, such as the center of a ring.
+	outside := fillOutside(region, bounds.Sub(b.Min))
+
+	icon := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			i, j := x-bounds.Min.X, y-bounds.Min.Y
+			if outside.Get(i, j) {
+				continue
+			}
+			c := toRGBA(im.At(x, y))
+			c.A = 255
+			icon.SetRGBA(i, j, c)
+		}
+	}
+	return icon, nil
+}
+
+// fillOutside returns the pixels of r, relative to r.Min, that are
+// connected to r's edge without passing through region.
+func fillOutside(region *bitmap2, r image.Rectangle) *bitmap2 {
+	// Pad by one pixel so that all of the edge is connected.
+	w, h := r.Dx()+2, r.Dy()+2
+	in := func(i, j int) bool {
+		x, y := i-1+r.Min.X, j-1+r.Min.Y
+		return image.Pt(x, y).In(r) && region.Get(x, y)
+	}
+	seen := newBitmap2(w, h)
+	seen.Set(0, 0)
+	stack := []image.Point{{0, 0}}
+	for len(stack) > 0 {
+		p := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for _, d := range [...]image.Point{{1, 0}, {-1, 0}, {0, 1}, {0, -1}} {
+			q := p.Add(d)
+			if q.X < 0 || q.Y < 0 || q.X >= w || q.Y >= h || seen.Get(q.X, q.Y) || in(q.X, q.Y) {
+				continue
+			}
+			seen.Set(q.X, q.Y)
+			stack = append(stack, q)
+		}
+	}
+
+	out := newBitmap2(r.Dx(), r.Dy())
+	for j := 0; j < r.Dy(); j++ {
+		for i := 0; i < r.Dx(); i++ {
+			if seen.Get(i+1, j+1) {
+				out.Set(i, j)
+			}
+		}
+	}
+	return out
+}
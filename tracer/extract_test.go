@@ -0,0 +1,50 @@
+package tracer
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+func TestExtractIcon(t *testing.T) {
+	red := color.RGBA{255, 0, 0, 255}
+	gray := color.RGBA{128, 128, 128, 255}
+
+	im := image.NewRGBA(image.Rect(0, 0, 30, 20))
+	draw.Draw(im, im.Rect, image.NewUniform(gray), image.ZP, draw.Src)
+	// ring with a white center
+	draw.Draw(im, image.Rect(5, 5, 10, 10), image.NewUniform(red), image.ZP, draw.Src)
+	im.SetRGBA(7, 7, color.RGBA{255, 255, 255, 255})
+	im.SetRGBA(5, 5, gray) // clipped corner
+	// a nearby line that is not part of the marker
+	draw.Draw(im, image.Rect(12, 0, 13, 20), image.NewUniform(red), image.ZP, draw.Src)
+
+	icon, err := ExtractIcon(im, image.Pt(5, 6), 0.9, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if icon.Rect != image.Rect(0, 0, 5, 5) {
+		t.Fatalf("want 5x5 icon, have %v", icon.Rect)
+	}
+	if icon.RGBAAt(0, 0).A != 0 {
+		t.Errorf("want clipped corner to be transparent, have %v", icon.RGBAAt(0, 0))
+	}
+	for y := 0; y < 5; y++ {
+		for x := 0; x < 5; x++ {
+			if x == 0 && y == 0 {
+				continue
+			}
+			if icon.RGBAAt(x, y) != im.RGBAAt(x+5, y+5) {
+				t.Errorf("pixel (%d, %d): want %v, have %v", x, y, im.RGBAAt(x+5, y+5), icon.RGBAAt(x, y))
+			}
+		}
+	}
+	if s := NewIconMatcher(icon).MatchStrength(7, 7, im); s != 1 {
+		t.Errorf("want icon to match marker exactly, have strength %f", s)
+	}
+
+	if _, err := ExtractIcon(im, image.Pt(12, 3), 0.9, 10); err == nil {
+		t.Errorf("want error for marker larger than max size")
+	}
+}
@@ -0,0 +1,309 @@
+package tracer
+
+import (
+	"image"
+	"math"
+	"math/cmplx"
+	"runtime"
+	"sync"
+)
+
+// fft computes the discrete Fourier transform of a in place.
+// len(a) must be a power of two.
+func fft(a []complex128, inverse bool) {
+	n := len(a)
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			a[i], a[j] = a[j], a[i]
+		}
+	}
+
+	sign := -1.0
+	if inverse {
+		sign = 1
+	}
+	for size := 2; size <= n; size <<= 1 {
+		step := cmplx.Rect(1, sign*2*math.Pi/float64(size))
+		for start := 0; start < n; start += size {
+			w := complex(1, 0)
+			for k := 0; k < size/2; k++ {
+				u := a[start+k]
+				v := a[start+k+size/2] * w
+				a[start+k] = u + v
+				a[start+k+size/2] = u - v
+				w *= step
+			}
+		}
+	}
+
+	if inverse {
+		scale := complex(1/float64(n), 0)
+		for i := range a {
+			a[i] *= scale
+		}
+	}
+}
+
+// fft2D computes the 2D discrete Fourier transform of the n x n array a
+// (row-major) in place. tmp must have length n.
+func fft2D(a []complex128, n int, inverse bool, tmp []complex128) {
+	for y := 0; y < n; y++ {
+		fft(a[y*n:(y+1)*n], inverse)
+	}
+	for x := 0; x < n; x++ {
+		for y := 0; y < n; y++ {
+			tmp[y] = a[y*n+x]
+		}
+		fft(tmp, inverse)
+		for y := 0; y < n; y++ {
+			a[y*n+x] = tmp[y]
+		}
+	}
+}
+
+func nextPow2(v int) int {
+	n := 1
+	for n < v {
+		n <<= 1
+	}
+	return n
+}
+
+// A StrengthMapper is a BlobMatcher that can score every location in an
+// image at once, faster than calling MatchStrength at each pixel.
+type StrengthMapper interface {
+	BlobMatcher
+
+	// StrengthMap returns the match strength at each pixel of im,
+	// in row-major order over im.Rect. Strengths of at most min may be
+	// returned as 0.
+	StrengthMap(im *image.RGBA, min float64) []float64
+}
+
+// FFTIconMatcher matches an icon like IconMatcher, with
+// MeanColorAccuracy, but uses FFT-based correlation to skip locations
+// that cannot match.
+//
+// The mean of colorDist cannot be computed as a sum of correlations, but
+// the mean of its square can. Since colorDist is at most 1, its mean is
+// at least its mean square, so 1 - the mean square bounds the accuracy
+// from above, and only locations where the bound is above the threshold
+// are scored exactly.
+type FFTIconMatcher struct {
+	icon *IconMatcher
+
+	mask    image.Rectangle // bounds of opaque icon pixels, relative to off
+	maskSum float64         // number of opaque icon pixels
+
+	tileSize int
+	// FFTs of the icon-side terms of the score, conjugated.
+	tmplFFT [numCorrTerms][]complex128
+}
+
+// The sum over opaque icon pixels of d^2, where d is colorDist or 1 for
+// transparent image pixels, is
+//
+//	<o, T^2/4 - M> - 1/2 sum_c <I_c, T_c> + <I^2, M/4> + |M|
+//
+// where <a, b> is the correlation of image-side term a and icon-side
+// term b, o is image opacity, and M is the icon mask.
+const (
+	corrOpacity = iota // o with T^2/4 - M
+	corrR              // I_r with -T_r/2
+	corrG              // I_g with -T_g/2
+	corrB              // I_b with -T_b/2
+	corrSq             // I^2 with M/4
+	numCorrTerms
+)
+
+// The upper bound on the accuracy from the correlations may be off by
+// this much from rounding, so locations within it of the threshold are
+// still scored exactly.
+const fftBoundTolerance = 1e-6
+
+var _ StrengthMapper = &FFTIconMatcher{}
+
+func NewFFTIconMatcher(icon image.Image) *FFTIconMatcher {
+	m := &FFTIconMatcher{icon: NewIconMatcher(icon)}
+	ir := m.icon.i.Rect
+
+	first := true
+	for j := 0; j < ir.Dy(); j++ {
+		for i := 0; i < ir.Dx(); i++ {
+			if m.icon.i.RGBAAt(i+ir.Min.X, j+ir.Min.Y).A == 0 {
+				continue
+			}
+			p := image.Rect(i, j, i+1, j+1).Sub(m.icon.off)
+			if first {
+				m.mask = p
+				first = false
+			} else {
+				m.mask = m.mask.Union(p)
+			}
+			m.maskSum++
+		}
+	}
+
+	maxDim := ir.Dx()
+	if ir.Dy() > maxDim {
+		maxDim = ir.Dy()
+	}
+	m.tileSize = nextPow2(4 * maxDim)
+	if m.tileSize < 256 {
+		m.tileSize = 256
+	}
+
+	n := m.tileSize
+	tmp := make([]complex128, n)
+	for k := range m.tmplFFT {
+		m.tmplFFT[k] = make([]complex128, n*n)
+	}
+	for j := 0; j < ir.Dy(); j++ {
+		for i := 0; i < ir.Dx(); i++ {
+			c := m.icon.i.RGBAAt(i+ir.Min.X, j+ir.Min.Y)
+			if c.A == 0 {
+				continue
+			}
+			r, g, b := float64(c.R)/255, float64(c.G)/255, float64(c.B)/255
+			idx := j*n + i
+			m.tmplFFT[corrOpacity][idx] = complex((r*r+g*g+b*b)/4-1, 0)
+			m.tmplFFT[corrR][idx] = complex(-r/2, 0)
+			m.tmplFFT[corrG][idx] = complex(-g/2, 0)
+			m.tmplFFT[corrB][idx] = complex(-b/2, 0)
+			m.tmplFFT[corrSq][idx] = 0.25
+		}
+	}
+	for k := range m.tmplFFT {
+		fft2D(m.tmplFFT[k], n, false, tmp)
+		for i, v := range m.tmplFFT[k] {
+			m.tmplFFT[k][i] = cmplx.Conj(v)
+		}
+	}
+	return m
+}
+
+func (m *FFTIconMatcher) EraseMatch(x, y int, im *image.RGBA) {
+	m.icon.EraseMatch(x, y, im)
+}
+
+func (m *FFTIconMatcher) fits(x, y int, im *image.RGBA) bool {
+	return m.mask.Add(image.Pt(x, y)).In(im.Rect)
+}
+
+func (m *FFTIconMatcher) MatchStrength(x, y int, im *image.RGBA) float64 {
+	return m.icon.MatchStrength(x, y, im)
+}
+
+// StrengthMap bounds the accuracy at every location using overlap-save
+// correlation over square tiles of the image, and scores the locations
+// whose bound is above min.
+func (m *FFTIconMatcher) StrengthMap(im *image.RGBA, min float64) []float64 {
+	b := im.Bounds()
+	w, h := b.Dx(), b.Dy()
+	out := make([]float64, w*h)
+	if m.maskSum == 0 {
+		return out
+	}
+
+	n := m.tileSize
+	tw, th := m.icon.i.Rect.Dx(), m.icon.i.Rect.Dy()
+	stepX, stepY := n-tw+1, n-th+1
+
+	tiles := make(chan image.Point)
+	var wg sync.WaitGroup
+	for i := 0; i < runtime.GOMAXPROCS(0); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var bufs fftTileBufs
+			for o := range tiles {
+				m.strengthTile(im, o, min, out, &bufs)
+			}
+		}()
+	}
+	// Tile origins are top-left icon positions, which may start before
+	// the image so that centers near the edge are covered.
+	for oy := -m.icon.off.Y; oy < h; oy += stepY {
+		for ox := -m.icon.off.X; ox < w; ox += stepX {
+			tiles <- image.Pt(ox, oy)
+		}
+	}
+	close(tiles)
+	wg.Wait()
+	return out
+}
+
+type fftTileBufs struct {
+	img, acc, tmp []complex128
+}
+
+// strengthTile fills in out for icon positions whose top-left corner is
+// within the valid region of the tile at origin o (relative to im.Rect.Min).
+func (m *FFTIconMatcher) strengthTile(im *image.RGBA, o image.Point, min float64, out []float64, bufs *fftTileBufs) {
+	b := im.Bounds()
+	w, h := b.Dx(), b.Dy()
+	n := m.tileSize
+	if bufs.img == nil {
+		bufs.img = make([]complex128, n*n)
+		bufs.acc = make([]complex128, n*n)
+		bufs.tmp = make([]complex128, n)
+	}
+	for i := range bufs.acc {
+		bufs.acc[i] = 0
+	}
+
+	for k := 0; k < numCorrTerms; k++ {
+		for j := 0; j < n; j++ {
+			for i := 0; i < n; i++ {
+				x, y := o.X+i, o.Y+j
+				v := 0.0
+				if x >= 0 && y >= 0 && x < w && y < h {
+					c := im.RGBAAt(x+b.Min.X, y+b.Min.Y)
+					if c.A != 0 {
+						r, g, bl := float64(c.R)/255, float64(c.G)/255, float64(c.B)/255
+						switch k {
+						case corrOpacity:
+							v = 1
+						case corrR:
+							v = r
+						case corrG:
+							v = g
+						case corrB:
+							v = bl
+						case corrSq:
+							v = r*r + g*g + bl*bl
+						}
+					}
+				}
+				bufs.img[j*n+i] = complex(v, 0)
+			}
+		}
+		fft2D(bufs.img, n, false, bufs.tmp)
+		for i, v := range bufs.img {
+			bufs.acc[i] += v * m.tmplFFT[k][i]
+		}
+	}
+	fft2D(bufs.acc, n, true, bufs.tmp)
+
+	tw, th := m.icon.i.Rect.Dx(), m.icon.i.Rect.Dy()
+	for v := 0; v <= n-th; v++ {
+		for u := 0; u <= n-tw; u++ {
+			cx, cy := o.X+u+m.icon.off.X, o.Y+v+m.icon.off.Y
+			if cx < 0 || cy < 0 || cx >= w || cy >= h {
+				continue
+			}
+			if !m.fits(cx+b.Min.X, cy+b.Min.Y, im) {
+				continue // leave as 0
+			}
+			sum := real(bufs.acc[v*n+u]) + m.maskSum
+			if 1-sum/m.maskSum > min-fftBoundTolerance {
+				out[cy*w+cx] = m.icon.MatchStrength(cx+b.Min.X, cy+b.Min.Y, im)
+			}
+		}
+	}
+}
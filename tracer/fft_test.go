@@ -0,0 +1,142 @@
+package tracer
+
+import (
+	"context"
+	"image"
+	"image/color"
+	_ "image/png"
+	"math"
+	"math/cmplx"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFFT(t *testing.T) {
+	rng := rand.New(rand.NewSource(0))
+	const n = 16
+	in := make([]complex128, n)
+	for i := range in {
+		in[i] = complex(rng.Float64(), rng.Float64())
+	}
+
+	have := append([]complex128(nil), in...)
+	fft(have, false)
+	for k := 0; k < n; k++ {
+		var want complex128
+		for j := 0; j < n; j++ {
+			want += in[j] * cmplx.Rect(1, -2*math.Pi*float64(j*k)/n)
+		}
+		if cmplx.Abs(want-have[k]) > 1e-9 {
+			t.Errorf("bin %d: want %v, have %v", k, want, have[k])
+		}
+	}
+
+	fft(have, true)
+	for i := range in {
+		if cmplx.Abs(in[i]-have[i]) > 1e-9 {
+			t.Errorf("inverse %d: want %v, have %v", i, in[i], have[i])
+		}
+	}
+}
+
+func TestFFTIconMatcherStrengthMap(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	randColor := func() color.RGBA {
+		return color.RGBA{uint8(rng.Intn(256)), uint8(rng.Intn(256)), uint8(rng.Intn(256)), 255}
+	}
+
+	icon := image.NewRGBA(image.Rect(0, 0, 5, 4))
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 5; x++ {
+			if x == 0 && y == 0 {
+				continue // transparent corner
+			}
+			icon.SetRGBA(x, y, randColor())
+		}
+	}
+
+	// Larger than a tile so that tiling is exercised.
+	im := image.NewRGBA(image.Rect(3, 2, 303, 282))
+	for y := im.Rect.Min.Y; y < im.Rect.Max.Y; y++ {
+		for x := im.Rect.Min.X; x < im.Rect.Max.X; x++ {
+			if rng.Intn(20) != 0 {
+				im.SetRGBA(x, y, randColor())
+			}
+		}
+	}
+
+	// Strengths at most min may be left out.
+	const min = 0.65
+	ref := NewIconMatcher(icon)
+	strengths := NewFFTIconMatcher(icon).StrengthMap(im, min)
+	scored := 0
+	for y := im.Rect.Min.Y; y < im.Rect.Max.Y; y++ {
+		for x := im.Rect.Min.X; x < im.Rect.Max.X; x++ {
+			want := ref.MatchStrength(x, y, im)
+			have := strengths[(y-im.Rect.Min.Y)*im.Rect.Dx()+x-im.Rect.Min.X]
+			if want > min {
+				scored++
+			}
+			if (want > min || have != 0) && math.Abs(want-have) > 1e-9 {
+				t.Fatalf("(%d, %d): want %f, have %f", x, y, want, have)
+			}
+		}
+	}
+	if scored == 0 {
+		t.Fatalf("no strengths above %v", min)
+	}
+}
+
+var benchmarkMaps = []struct {
+	name, image, icon string
+}{
+	{"akamai", "orig.png", "icon.png"},
+	{"aws", "orig.png", "icon.png"},
+	{"cloudflare", "orig2.png", "icon2.png"},
+	{"google-b4", "orig.png", "icon.png"},
+}
+
+func readTestImage(b *testing.B, p string) image.Image {
+	f, err := os.Open(p)
+	if err != nil {
+		b.Skipf("unable to open %s: %v", p, err)
+	}
+	defer f.Close()
+	im, _, err := image.Decode(f)
+	if err != nil {
+		b.Fatalf("unable to decode %s: %v", p, err)
+	}
+	return im
+}
+
+func benchmarkScoreCandidates(b *testing.B, newMatcher func(image.Image) BlobMatcher) {
+	for _, bm := range benchmarkMaps {
+		b.Run(bm.name, func(b *testing.B) {
+			dir := filepath.Join("..", "data", bm.name)
+			im := readTestImage(b, filepath.Join(dir, bm.image))
+			icon := readTestImage(b, filepath.Join(dir, bm.icon))
+			m := newMatcher(icon)
+			tr, err := NewNode(NodeConfig{Classes: []NodeClass{{Matcher: m, StrengthThreshold: 0.5}}}, im, func(string, ...interface{}) {})
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := tr.candidates(context.Background(), 0); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(im.Bounds().Dx()*im.Bounds().Dy()), "pixels")
+		})
+	}
+}
+
+func BenchmarkScoreCandidatesBruteForce(b *testing.B) {
+	benchmarkScoreCandidates(b, func(icon image.Image) BlobMatcher { return NewIconMatcher(icon) })
+}
+
+func BenchmarkScoreCandidatesFFT(b *testing.B) {
+	benchmarkScoreCandidates(b, func(icon image.Image) BlobMatcher { return NewFFTIconMatcher(icon) })
+}
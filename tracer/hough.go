@@ -0,0 +1,169 @@
+package tracer
+
+import (
+	"image"
+	"math"
+	"sort"
+)
+
+// HoughCircleDetector finds circular node markers, filled or hollow,
+// using a gradient-directed Hough transform.
+//
+// Each edge pixel votes for the centers that lie MinRadiusPx to
+// MaxRadiusPx away along its gradient. The score of a circle is roughly
+// the fraction of its circumference that voted for it.
+type HoughCircleDetector struct {
+	MinRadiusPx int
+	MaxRadiusPx int
+
+	// Minimum gradient magnitude for a pixel to count as an edge,
+	// 0-1, 1 is a black to white step.
+	MinEdgeStrength float64
+}
+
+var _ BlobDetector = &HoughCircleDetector{}
+
+// Circles with scores below this are noise and not worth reporting.
+const minHoughScore = 0.1
+
+type edgePx struct {
+	x, y   int
+	gx, gy float64 // unit gradient
+}
+
+func (d *HoughCircleDetector) Detect(im *image.RGBA) []Blob {
+	b := im.Bounds()
+	w, h := b.Dx(), b.Dy()
+	edges := sobelEdges(im, d.MinEdgeStrength)
+
+	minR := d.MinRadiusPx
+	if minR < 1 {
+		minR = 1
+	}
+
+	type circleCand struct {
+		Blob
+		ratio float64 // uncapped score
+	}
+
+	var cands []circleCand
+	acc := make([]float64, w*h)
+	smoothed := make([]float64, w*h)
+	for r := minR; r <= d.MaxRadiusPx; r++ {
+		for i := range acc {
+			acc[i] = 0
+		}
+		for _, e := range edges {
+			// Vote in both directions so that both dark-on-light and
+			// light-on-dark circles are found.
+			for _, sign := range [2]float64{-1, 1} {
+				cx := e.x + int(math.Round(sign*float64(r)*e.gx))
+				cy := e.y + int(math.Round(sign*float64(r)*e.gy))
+				if cx >= 0 && cy >= 0 && cx < w && cy < h {
+					acc[cx+cy*w]++
+				}
+			}
+		}
+
+		// Rounding spreads votes over neighboring centers, so sum them
+		// before looking for peaks.
+		box3x3(acc, smoothed, w, h)
+
+		// A sharp edge is two pixels wide, one on either side.
+		expectedVotes := 2 * 2 * math.Pi * float64(r)
+		for y := 1; y < h-1; y++ {
+			for x := 1; x < w-1; x++ {
+				v := smoothed[x+y*w]
+				ratio := v / expectedVotes
+				if ratio < minHoughScore || !isLocalMax(smoothed, w, x, y) {
+					continue
+				}
+				dx := parabolaPeak(smoothed[x-1+y*w], v, smoothed[x+1+y*w])
+				dy := parabolaPeak(smoothed[x+(y-1)*w], v, smoothed[x+(y+1)*w])
+				cands = append(cands, circleCand{
+					Blob: Blob{
+						X:      float64(x+b.Min.X) + dx,
+						Y:      float64(y+b.Min.Y) + dy,
+						Radius: float64(r),
+						Score:  math.Min(1, ratio),
+					},
+					ratio: ratio,
+				})
+			}
+		}
+	}
+
+	// Keep the best circle among those with nearby centers.
+	sort.SliceStable(cands, func(i, j int) bool {
+		return cands[i].ratio > cands[j].ratio
+	})
+	var blobs []Blob
+	for _, c := range cands {
+		overlaps := false
+		for _, k := range blobs {
+			if math.Hypot(c.X-k.X, c.Y-k.Y) < math.Max(c.Radius, k.Radius) {
+				overlaps = true
+				break
+			}
+		}
+		if !overlaps {
+			blobs = append(blobs, c.Blob)
+		}
+	}
+	return blobs
+}
+
+func isLocalMax(v []float64, w, x, y int) bool {
+	c := v[x+y*w]
+	for dy := -1; dy <= 1; dy++ {
+		for dx := -1; dx <= 1; dx++ {
+			if v[x+dx+(y+dy)*w] > c {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func box3x3(in, out []float64, w, h int) {
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			sum := 0.0
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					i, j := x+dx, y+dy
+					if i >= 0 && j >= 0 && i < w && j < h {
+						sum += in[i+j*w]
+					}
+				}
+			}
+			out[x+y*w] = sum
+		}
+	}
+}
+
+func luma(im *image.RGBA, x, y int) float64 {
+	c := im.RGBAAt(x, y)
+	return (0.299*float64(c.R) + 0.587*float64(c.G) + 0.114*float64(c.B)) / 255
+}
+
+// sobelEdges returns the pixels of im whose normalized gradient magnitude
+// is at least minStrength. Coordinates are relative to im.Rect.Min.
+func sobelEdges(im *image.RGBA, minStrength float64) []edgePx {
+	b := im.Bounds()
+	var edges []edgePx
+	for y := b.Min.Y + 1; y < b.Max.Y-1; y++ {
+		for x := b.Min.X + 1; x < b.Max.X-1; x++ {
+			gx := luma(im, x+1, y-1) + 2*luma(im, x+1, y) + luma(im, x+1, y+1) -
+				luma(im, x-1, y-1) - 2*luma(im, x-1, y) - luma(im, x-1, y+1)
+			gy := luma(im, x-1, y+1) + 2*luma(im, x, y+1) + luma(im, x+1, y+1) -
+				luma(im, x-1, y-1) - 2*luma(im, x, y-1) - luma(im, x+1, y-1)
+			mag := math.Hypot(gx, gy)
+			if mag == 0 || mag/4 < minStrength {
+				continue
+			}
+			edges = append(edges, edgePx{x - b.Min.X, y - b.Min.Y, gx / mag, gy / mag})
+		}
+	}
+	return edges
+}
@@ -0,0 +1,62 @@
+package tracer
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"github.com/fogleman/gg"
+)
+
+func TestHoughCircleDetector(t *testing.T) {
+	ctx := gg.NewContext(120, 80)
+	ctx.SetRGB(1, 1, 1)
+	ctx.Clear()
+	ctx.SetRGB(0.8, 0, 0)
+	ctx.DrawCircle(30, 30, 6) // filled
+	ctx.Fill()
+	ctx.DrawCircle(30, 60, 9) // filled
+	ctx.Fill()
+	ctx.SetLineWidth(2)
+	ctx.DrawCircle(80, 40, 12) // hollow
+	ctx.Stroke()
+	ctx.DrawRectangle(100, 5, 15, 15) // not a circle
+	ctx.Fill()
+
+	d := &HoughCircleDetector{MinRadiusPx: 3, MaxRadiusPx: 15, MinEdgeStrength: 0.1}
+
+	tr, err := NewNode(NodeConfig{Classes: []NodeClass{{
+		Detector:          d,
+		StrengthThreshold: 0.8,
+		MaxCount:          10,
+	}}}, ctx.Image(), t.Logf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.Find(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Pixel (x, y) covers [x, x+1) x [y, y+1), so circles drawn at
+	// integer coordinates are centered at a half pixel.
+	want := []Node{
+		{X: 29.5, Y: 29.5, Radius: 6},
+		{X: 29.5, Y: 59.5, Radius: 9},
+		{X: 79.5, Y: 39.5, Radius: 12},
+	}
+	have := tr.Graph().Nodes
+	if len(have) != len(want) {
+		t.Fatalf("want nodes %v, have %v", want, have)
+	}
+	for _, w := range want {
+		found := false
+		for _, h := range have {
+			if math.Hypot(h.X-w.X, h.Y-w.Y) < 0.25 && h.Radius == w.Radius {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("want node %v, have %v", w, have)
+		}
+	}
+}
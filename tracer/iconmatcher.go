@@ -3,23 +3,34 @@ package tracer
 import (
 	"image"
 	"image/color"
+	"math"
 )
 
 type IconMatcher struct {
-	i   *image.RGBA
-	off image.Point
+	i      *image.RGBA
+	off    image.Point
+	metric MatchMetric
 }
 
-var _ BlobMatcher = &IconMatcher{}
+var _ MetricMatcher = &IconMatcher{}
 
 func NewIconMatcher(im image.Image) *IconMatcher {
 	return &IconMatcher{
-		copyToRGBA(im),
-		image.Pt(im.Bounds().Dx()/2, im.Bounds().Dy()/2),
+		i:   copyToRGBA(im),
+		off: image.Pt(im.Bounds().Dx()/2, im.Bounds().Dy()/2),
 	}
 }
 
+func (m *IconMatcher) WithMetric(mm MatchMetric) BlobMatcher {
+	m2 := *m
+	m2.metric = mm
+	return &m2
+}
+
 func (m *IconMatcher) MatchStrength(x, y int, im *image.RGBA) float64 {
+	if m.metric == NormalizedCrossCorrelation {
+		return m.ncc(x, y, im)
+	}
 	sum := 0.0
 	num := 0.0
 	for j := 0; j < m.i.Rect.Dy(); j++ {
@@ -45,6 +56,74 @@ func (m *IconMatcher) MatchStrength(x, y int, im *image.RGBA) float64 {
 	return sum / num
 }
 
+// ncc computes the zero-mean normalized cross-correlation of the icon
+// and image for each RGB channel, weighting pixels by the alpha of both.
+// The result is the mean over channels in which the icon is not flat,
+// clamped to [0, 1].
+func (m *IconMatcher) ncc(x, y int, im *image.RGBA) float64 {
+	var (
+		sumW, iconW  float64
+		sumT, sumI   [3]float64
+		sumTT, sumII [3]float64
+		sumTI        [3]float64
+	)
+	for j := 0; j < m.i.Rect.Dy(); j++ {
+		for i := 0; i < m.i.Rect.Dx(); i++ {
+			iconColor := m.i.RGBAAt(i+m.i.Rect.Min.X, j+m.i.Rect.Min.Y)
+			if iconColor.A == 0 {
+				continue
+			}
+			imPt := image.Pt(x+i, y+j).Sub(m.off)
+			if !imPt.In(im.Rect) {
+				return 0
+			}
+			imColor := im.RGBAAt(imPt.X, imPt.Y)
+			iconW += float64(iconColor.A) / 255
+			w := float64(iconColor.A) / 255 * float64(imColor.A) / 255
+			if w == 0 {
+				continue
+			}
+			sumW += w
+			tc := [3]uint8{iconColor.R, iconColor.G, iconColor.B}
+			ic := [3]uint8{imColor.R, imColor.G, imColor.B}
+			for c := 0; c < 3; c++ {
+				t := float64(tc[c]) / 255
+				v := float64(ic[c]) / 255
+				sumT[c] += w * t
+				sumI[c] += w * v
+				sumTT[c] += w * t * t
+				sumII[c] += w * v * v
+				sumTI[c] += w * t * v
+			}
+		}
+	}
+	// Mostly transparent (e.g. erased) regions do not match.
+	if sumW == 0 || sumW < iconW/2 {
+		return 0
+	}
+
+	const eps = 1e-9
+	total := 0.0
+	numChannels := 0
+	for c := 0; c < 3; c++ {
+		varT := sumTT[c] - sumT[c]*sumT[c]/sumW
+		if varT < eps {
+			continue // flat channel in icon, correlation is undefined
+		}
+		numChannels++
+		varI := sumII[c] - sumI[c]*sumI[c]/sumW
+		if varI < eps {
+			continue // flat image region, no correlation
+		}
+		cov := sumTI[c] - sumT[c]*sumI[c]/sumW
+		total += cov / math.Sqrt(varT*varI)
+	}
+	if numChannels == 0 {
+		return 0
+	}
+	return math.Max(0, math.Min(1, total/float64(numChannels)))
+}
+
 func (m *IconMatcher) EraseMatch(x, y int, im *image.RGBA) {
 	empty := color.RGBA{}
 	for j := 0; j < m.i.Rect.Dy(); j++ {
@@ -3,6 +3,7 @@ package tracer
 import (
 	"image"
 	"image/color"
+	"math"
 	"strings"
 	"testing"
 )
@@ -105,3 +106,57 @@ func imToString(im *image.RGBA) string {
 	}
 	return buf.String()
 }
+
+func TestIconMatcherNCC(t *testing.T) {
+	gray := func(v uint8) color.RGBA { return color.RGBA{v, v, v, 255} }
+
+	icon := image.NewRGBA(image.Rect(0, 0, 3, 3))
+	iconVals := [3][3]uint8{
+		{200, 50, 200},
+		{50, 50, 50},
+		{200, 50, 200},
+	}
+	for y := range iconVals {
+		for x := range iconVals[y] {
+			icon.SetRGBA(x, y, gray(iconVals[y][x]))
+		}
+	}
+
+	im := image.NewRGBA(image.Rect(0, 0, 10, 5))
+	for y := 0; y < 5; y++ {
+		for x := 0; x < 10; x++ {
+			im.SetRGBA(x, y, gray(110)) // flat, close to the icon's mean
+		}
+	}
+	// Dimmer, lower contrast copy of the icon centered at (2, 2).
+	for y := range iconVals {
+		for x := range iconVals[y] {
+			im.SetRGBA(x+1, y+1, gray(30+iconVals[y][x]/2))
+		}
+	}
+
+	m := NewIconMatcher(icon).WithMetric(NormalizedCrossCorrelation)
+	if st := m.MatchStrength(2, 2, im); math.Abs(st-1) > 1e-6 {
+		t.Errorf("want strength 1 at icon, have %f", st)
+	}
+	if st := m.MatchStrength(7, 2, im); st != 0 {
+		t.Errorf("want strength 0 at flat background, have %f", st)
+	}
+
+	m.EraseMatch(2, 2, im)
+	if st := m.MatchStrength(2, 2, im); st != 0 {
+		t.Errorf("want strength 0 after erasing, have %f", st)
+	}
+}
+
+func TestNewNodeUnsupportedMetric(t *testing.T) {
+	icon := image.NewRGBA(image.Rect(0, 0, 3, 3))
+	im := image.NewRGBA(image.Rect(0, 0, 10, 10))
+	_, err := NewNode(NodeConfig{
+		Classes: []NodeClass{{Matcher: NewFFTIconMatcher(icon)}},
+		Metric:  NormalizedCrossCorrelation,
+	}, im, t.Logf)
+	if err == nil {
+		t.Error("want error for a matcher without NCC support")
+	}
+}
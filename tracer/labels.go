@@ -0,0 +1,322 @@
+package tracer
+
+import (
+	"context"
+	"image"
+	"image/draw"
+	"math"
+	"sort"
+	"strings"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+// DefaultAlphabet is the set of characters that a LabelTracer looks for
+// by default.
+const DefaultAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+
+// A LabelConfig configures a LabelTracer.
+type LabelConfig struct {
+	Face     font.Face // font the labels are printed in, at the image's scale
+	Alphabet string    // characters to look for
+
+	// Minimum normalized cross-correlation between a glyph and the image.
+	MinStrength float64
+
+	MaxDistPx       float64 // maximum distance between a node and its label
+	MaxLabelWidthPx float64 // labels are cut off this far from the node
+	NodeRadiusPx    float64 // pixels this close to a node are part of its marker
+
+	Mask     *Mask        // optional
+	Progress ProgressFunc // optional
+}
+
+// LabelTracer names nodes after the nearest text in the image.
+//
+// Each character of the alphabet is rendered with Face and matched like
+// a node icon near the nodes. The matched glyphs are then joined into
+// strings by position.
+type LabelTracer struct {
+	c   LabelConfig
+	im  *image.RGBA
+	g   XYGraph
+	log func(string, ...interface{})
+}
+
+func NewLabel(c LabelConfig, tim image.Image, g *XYGraph, logfunc func(string, ...interface{})) *LabelTracer {
+	t := &LabelTracer{c: c, im: copyToRGBA(tim), g: *g, log: logfunc}
+	t.g.Nodes = append([]Node(nil), g.Nodes...)
+	t.g.Bounds = tim.Bounds()
+	return t
+}
+
+func (t *LabelTracer) Graph() *XYGraph {
+	g := t.g
+	return &g
+}
+
+// Labels must have at least this many characters; single glyphs are
+// usually bits of lines or markers.
+const minLabelLen = 2
+
+type glyphMatch struct {
+	r    rune
+	x, y float64 // center of the glyph's cell
+	adv  float64 // advance width
+}
+
+type labelText struct {
+	text   string
+	bounds image.Rectangle
+}
+
+// Find names the nodes. It returns ctx.Err() if ctx is cancelled before
+// it is done.
+func (t *LabelTracer) Find(ctx context.Context) error {
+	m := t.c.Face.Metrics()
+	lineHeight := float64((m.Ascent + m.Descent).Ceil())
+
+	var classes []NodeClass
+	advances := make(map[rune]float64)
+	for _, r := range t.c.Alphabet {
+		cell, adv := renderGlyph(t.c.Face, r)
+		if cell == nil {
+			continue
+		}
+		advances[r] = adv
+		classes = append(classes, NodeClass{
+			Name:              string(r),
+			Matcher:           NewIconMatcher(cell),
+			StrengthThreshold: t.c.MinStrength,
+			MaxCount:          math.MaxInt32,
+		})
+	}
+
+	im := copyToRGBA(t.im)
+	t.c.Mask.Apply(im)
+
+	// Only search near nodes, but not on their markers, and only where
+	// there is ink.
+	mask := NewMask(t.g.Bounds)
+	var near []image.Rectangle
+	for _, n := range t.g.Nodes {
+		dx := t.c.MaxDistPx + t.c.MaxLabelWidthPx
+		dy := t.c.MaxDistPx + lineHeight
+		near = append(near, image.Rect(
+			int(math.Floor(n.X-dx)), int(math.Floor(n.Y-dy)),
+			int(math.Ceil(n.X+dx))+1, int(math.Ceil(n.Y+dy))+1))
+	}
+	mask.IncludeOnly(near)
+	for _, n := range t.g.Nodes {
+		mask.Exclude(squareAround(n.X, n.Y, t.c.NodeRadiusPx))
+	}
+	mask.ExcludeImage(inkImage(im, int(lineHeight/4)))
+
+	t.log("matching %d glyphs near %d nodes", len(classes), len(t.g.Nodes))
+	nt, err := NewNode(NodeConfig{
+		Classes:  classes,
+		Metric:   NormalizedCrossCorrelation,
+		Mask:     mask,
+		Progress: t.c.Progress,
+	}, im, func(string, ...interface{}) {})
+	if err != nil {
+		return err
+	}
+	if err := nt.Find(ctx); err != nil {
+		return err
+	}
+
+	var glyphs []glyphMatch
+	for _, n := range nt.Graph().Nodes {
+		r := []rune(n.Class)[0]
+		glyphs = append(glyphs, glyphMatch{r: r, x: n.X, y: n.Y, adv: advances[r]})
+	}
+	texts := joinGlyphs(glyphs, lineHeight)
+	t.log("found %d glyphs in %d strings", len(glyphs), len(texts))
+
+	t.assign(texts)
+	return nil
+}
+
+// Minimum edge strength of the outlines of glyphs.
+const minInkEdgeStrength = 0.2
+
+// inkImage returns an image that is white within r pixels of an edge in
+// im and black elsewhere.
+func inkImage(im *image.RGBA, r int) *image.Gray {
+	b := im.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	// sums[(y+1)*(w+1)+x+1] is the number of edge pixels above and to
+	// the left of (x, y), inclusive.
+	sums := make([]int, (w+1)*(h+1))
+	for _, e := range sobelEdges(im, minInkEdgeStrength) {
+		sums[(e.y+1)*(w+1)+e.x+1]++
+	}
+	for y := 1; y <= h; y++ {
+		for x := 1; x <= w; x++ {
+			i := y*(w+1) + x
+			sums[i] += sums[i-1] + sums[i-w-1] - sums[i-w-2]
+		}
+	}
+
+	out := image.NewGray(b)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			x0, y0 := imax(x-r, 0), imax(y-r, 0)
+			x1, y1 := imin(x+r+1, w), imin(y+r+1, h)
+			n := sums[y1*(w+1)+x1] - sums[y0*(w+1)+x1] - sums[y1*(w+1)+x0] + sums[y0*(w+1)+x0]
+			if n > 0 {
+				out.Pix[y*out.Stride+x] = 0xff
+			}
+		}
+	}
+	return out
+}
+
+func squareAround(x, y, r float64) image.Rectangle {
+	return image.Rect(
+		int(math.Floor(x-r)), int(math.Floor(y-r)),
+		int(math.Ceil(x+r))+1, int(math.Ceil(y+r))+1)
+}
+
+// renderGlyph draws r in black on an opaque white cell that is as wide
+// as its advance and as tall as a line, so that the centers of glyphs on
+// a line are level. It returns nil if r has no visible pixels.
+func renderGlyph(face font.Face, r rune) (*image.RGBA, float64) {
+	adv, ok := face.GlyphAdvance(r)
+	if !ok || adv.Round() < 1 {
+		return nil, 0
+	}
+	m := face.Metrics()
+	cell := image.NewRGBA(image.Rect(0, 0, adv.Round(), (m.Ascent + m.Descent).Ceil()))
+	draw.Draw(cell, cell.Rect, image.White, image.ZP, draw.Src)
+	d := font.Drawer{
+		Dst:  cell,
+		Src:  image.Black,
+		Face: face,
+		Dot:  fixed.P(0, m.Ascent.Ceil()),
+	}
+	d.DrawString(string(r))
+
+	for i := 0; i < len(cell.Pix); i += 4 {
+		if cell.Pix[i] != 0xff {
+			return cell, float64(adv) / 64
+		}
+	}
+	return nil, 0
+}
+
+// joinGlyphs groups glyphs that sit next to each other on the same line
+// into strings. Larger gaps between glyphs become spaces.
+func joinGlyphs(glyphs []glyphMatch, lineHeight float64) []labelText {
+	sort.Slice(glyphs, func(i, j int) bool { return glyphs[i].x < glyphs[j].x })
+
+	var lines [][]glyphMatch
+	for _, g := range glyphs {
+		best := -1
+		bestGap := math.Inf(1)
+		for i, l := range lines {
+			last := l[len(l)-1]
+			if math.Abs(g.y-last.y) > lineHeight/4 {
+				continue
+			}
+			gap := (g.x - g.adv/2) - (last.x + last.adv/2)
+			if gap < -lineHeight/4 || gap > lineHeight/2 {
+				continue
+			}
+			if gap < bestGap {
+				best = i
+				bestGap = gap
+			}
+		}
+		if best < 0 {
+			lines = append(lines, []glyphMatch{g})
+		} else {
+			lines[best] = append(lines[best], g)
+		}
+	}
+
+	var texts []labelText
+	for _, l := range lines {
+		if len(l) < minLabelLen {
+			continue
+		}
+		var sb strings.Builder
+		var b image.Rectangle
+		for i, g := range l {
+			if i > 0 {
+				prev := l[i-1]
+				if (g.x-g.adv/2)-(prev.x+prev.adv/2) > lineHeight/6 {
+					sb.WriteByte(' ')
+				}
+			}
+			sb.WriteRune(g.r)
+			gb := squareAround(g.x, g.y, 0).Inset(-int(math.Ceil(g.adv / 2)))
+			if i == 0 {
+				b = gb
+			} else {
+				b = b.Union(gb)
+			}
+		}
+		b.Min.Y = int(math.Floor(l[0].y - lineHeight/2))
+		b.Max.Y = int(math.Ceil(l[0].y + lineHeight/2))
+		texts = append(texts, labelText{sb.String(), b})
+	}
+	return texts
+}
+
+// assign names nodes after the closest texts, closest pairs first, so
+// that each text names at most one node.
+func (t *LabelTracer) assign(texts []labelText) {
+	type pair struct {
+		node, text int
+		dist       float64
+	}
+	var pairs []pair
+	for i, n := range t.g.Nodes {
+		for j, l := range texts {
+			if d := rectDist(n.X, n.Y, l.bounds); d <= t.c.MaxDistPx {
+				pairs = append(pairs, pair{i, j, d})
+			}
+		}
+	}
+	sort.SliceStable(pairs, func(i, j int) bool { return pairs[i].dist < pairs[j].dist })
+
+	named := make([]bool, len(t.g.Nodes))
+	used := make([]bool, len(texts))
+	numNamed := 0
+	for _, p := range pairs {
+		if named[p.node] || used[p.text] {
+			continue
+		}
+		named[p.node] = true
+		used[p.text] = true
+		t.g.Nodes[p.node].Name = texts[p.text].text
+		t.log("node %d at (%.1f, %.1f) is %q", p.node, t.g.Nodes[p.node].X, t.g.Nodes[p.node].Y, texts[p.text].text)
+		numNamed++
+	}
+	t.log("named %d of %d nodes", numNamed, len(t.g.Nodes))
+}
+
+// rectDist returns the distance from (x, y) to the closest point in r.
+func rectDist(x, y float64, r image.Rectangle) float64 {
+	dx := math.Max(0, math.Max(float64(r.Min.X)-x, x-float64(r.Max.X)))
+	dy := math.Max(0, math.Max(float64(r.Min.Y)-y, y-float64(r.Max.Y)))
+	return math.Hypot(dx, dy)
+}
+
+func imin(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func imax(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,62 @@
+package tracer
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+
+	"github.com/golang/freetype/truetype"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/math/fixed"
+)
+
+func TestLabelTracer(t *testing.T) {
+	f, err := truetype.Parse(goregular.TTF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	face := truetype.NewFace(f, &truetype.Options{Size: 14})
+
+	red := color.RGBA{255, 0, 0, 255}
+	im := image.NewRGBA(image.Rect(0, 0, 200, 100))
+	draw.Draw(im, im.Rect, image.White, image.ZP, draw.Src)
+
+	g := XYGraph{Bounds: im.Rect}
+	for _, l := range []struct {
+		node image.Point
+		text string
+	}{
+		{image.Pt(20, 30), "SFO"},
+		{image.Pt(120, 70), "New York"},
+	} {
+		draw.Draw(im, image.Rect(l.node.X-3, l.node.Y-3, l.node.X+4, l.node.Y+4), image.NewUniform(red), image.ZP, draw.Src)
+		d := font.Drawer{Dst: im, Src: image.Black, Face: face, Dot: fixed.P(l.node.X+8, l.node.Y+5)}
+		d.DrawString(l.text)
+		g.Nodes = append(g.Nodes, Node{X: float64(l.node.X), Y: float64(l.node.Y)})
+	}
+
+	tr := NewLabel(LabelConfig{
+		Face:            face,
+		Alphabet:        DefaultAlphabet,
+		MinStrength:     0.8,
+		MaxDistPx:       15,
+		MaxLabelWidthPx: 100,
+		NodeRadiusPx:    4,
+	}, im, &g, t.Logf)
+	if err := tr.Find(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"SFO", "New York"}
+	for i, n := range tr.Graph().Nodes {
+		if n.Name != want[i] {
+			t.Errorf("node %d: want name %q, have %q", i, want[i], n.Name)
+		}
+	}
+	if g.Nodes[0].Name != "" {
+		t.Errorf("input graph was modified")
+	}
+}
@@ -11,6 +11,12 @@ type lnnTracker struct {
 	EWMAPointThresh       int
 	DistPx                func(p1, p2 image.Point) float64
 
+	// Within crossings, runs go straight through and only take points
+	// that are within CrossingHalfWidthPx of their own line or closer to
+	// it than to the other.
+	Crossings           []crossing
+	CrossingHalfWidthPx float64
+
 	runs []lineRun
 }
 
@@ -20,8 +26,17 @@ func (t *lnnTracker) AddPoint(pt image.Point, time int) bool {
 		return true
 	}
 
+	// Points of the other line are ignored in crossings, which leaves
+	// gaps in lines that go through them, so reach farther there.
+	c := t.crossingAt(pt)
+	maxDist := t.AllowedGapPx
+	if c != nil {
+		maxDist = math.Max(maxDist, c.radius)
+	}
+
 	bestDist := math.Inf(1)
 	bestIdx := -1
+	var bestExit vec2
 	for i := range t.runs {
 		r := &t.runs[i]
 
@@ -29,7 +44,13 @@ func (t *lnnTracker) AddPoint(pt image.Point, time int) bool {
 			continue
 		}
 
-		if len(r.SeenPoints) > t.EWMAPointThresh {
+		var exit vec2
+		if c != nil {
+			exit = t.exitDir(r, c)
+			if !c.onLineOf(exit, pt, t.CrossingHalfWidthPx) {
+				continue // point is part of the other line
+			}
+		} else if len(r.SeenPoints) > t.EWMAPointThresh {
 			dirAngle := offAngle(
 				r.ewmaDir,
 				vec2{float64(pt.X - r.Dst().X), float64(pt.Y - r.Dst().Y)})
@@ -42,16 +63,27 @@ func (t *lnnTracker) AddPoint(pt image.Point, time int) bool {
 		if dist < bestDist {
 			bestDist = dist
 			bestIdx = i
+			bestExit = exit
 		}
 	}
 
-	if bestIdx == -1 || bestDist > t.AllowedGapPx {
+	if bestIdx == -1 || bestDist > maxDist {
 		return false // No good line found
 	}
 
 	// Otherwise, add to best candidate.
 	r := &t.runs[bestIdx]
-	if len(r.SeenPoints) == t.EWMAPointThresh-1 {
+	if c != nil {
+		// Leave the crossing heading out of the chosen arm.
+		if r.crossing != c {
+			r.crossing = c
+			r.exit = bestExit
+			if len(r.SeenPoints) >= t.EWMAPointThresh {
+				norm := math.Hypot(r.ewmaDir.X, r.ewmaDir.Y)
+				r.ewmaDir = vec2{norm * bestExit.X, norm * bestExit.Y}
+			}
+		}
+	} else if len(r.SeenPoints) == t.EWMAPointThresh-1 {
 		r.ewmaDir.X = float64(pt.X - r.Src().X)
 		r.ewmaDir.Y = float64(pt.Y - r.Src().Y)
 	} else if len(r.SeenPoints) >= t.EWMAPointThresh {
@@ -59,10 +91,31 @@ func (t *lnnTracker) AddPoint(pt image.Point, time int) bool {
 		r.ewmaDir.Y = alpha*float64(pt.Y-r.Dst().Y) + (1-alpha)*r.ewmaDir.Y
 	}
 
+	if c == nil {
+		r.crossing = nil
+	}
+
 	r.SeenPoints = append(r.SeenPoints, pt)
 	r.time = time
 
 	return true
 }
 
+func (t *lnnTracker) crossingAt(pt image.Point) *crossing {
+	for i := range t.Crossings {
+		if t.Crossings[i].contains(pt) {
+			return &t.Crossings[i]
+		}
+	}
+	return nil
+}
+
+// exitDir returns the direction that r leaves c in.
+func (t *lnnTracker) exitDir(r *lineRun, c *crossing) vec2 {
+	if r.crossing == c {
+		return r.exit
+	}
+	return c.exit(r.Dst())
+}
+
 func (t *lnnTracker) Runs() []lineRun { return t.runs }
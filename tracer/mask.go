@@ -0,0 +1,95 @@
+package tracer
+
+import (
+	"image"
+	"image/color"
+)
+
+// A Mask selects the pixels of an image that tracers may use.
+// Masked pixels are treated as transparent and never become nodes or
+// parts of links.
+type Mask struct {
+	rect    image.Rectangle
+	blocked *bitmap2
+}
+
+// NewMask returns a mask over r that allows every pixel.
+func NewMask(r image.Rectangle) *Mask {
+	return &Mask{rect: r, blocked: newBitmap2(r.Dx(), r.Dy())}
+}
+
+// Exclude masks all pixels in r.
+func (m *Mask) Exclude(r image.Rectangle) {
+	r = r.Intersect(m.rect)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			m.block(x, y)
+		}
+	}
+}
+
+// IncludeOnly masks all pixels that are not in any of rs.
+func (m *Mask) IncludeOnly(rs []image.Rectangle) {
+	for y := m.rect.Min.Y; y < m.rect.Max.Y; y++ {
+		for x := m.rect.Min.X; x < m.rect.Max.X; x++ {
+			p := image.Pt(x, y)
+			in := false
+			for _, r := range rs {
+				if p.In(r) {
+					in = true
+					break
+				}
+			}
+			if !in {
+				m.block(x, y)
+			}
+		}
+	}
+}
+
+// ExcludeImage masks pixels that are transparent or dark (less than
+// half brightness) in im. Pixels outside of im are masked.
+func (m *Mask) ExcludeImage(im image.Image) {
+	b := im.Bounds()
+	for y := m.rect.Min.Y; y < m.rect.Max.Y; y++ {
+		for x := m.rect.Min.X; x < m.rect.Max.X; x++ {
+			if !image.Pt(x, y).In(b) {
+				m.block(x, y)
+				continue
+			}
+			c := im.At(x, y)
+			_, _, _, a := c.RGBA()
+			if a == 0 || color.GrayModel.Convert(c).(color.Gray).Y < 128 {
+				m.block(x, y)
+			}
+		}
+	}
+}
+
+func (m *Mask) block(x, y int) {
+	m.blocked.Set(x-m.rect.Min.X, y-m.rect.Min.Y)
+}
+
+// Allowed reports whether (x, y) may be used. Pixels outside of the
+// mask's bounds are allowed.
+func (m *Mask) Allowed(x, y int) bool {
+	if m == nil || !image.Pt(x, y).In(m.rect) {
+		return true
+	}
+	return !m.blocked.Get(x-m.rect.Min.X, y-m.rect.Min.Y)
+}
+
+// Apply makes all masked pixels in im transparent.
+func (m *Mask) Apply(im *image.RGBA) {
+	if m == nil {
+		return
+	}
+	r := m.rect.Intersect(im.Rect)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			if !m.Allowed(x, y) {
+				im.SetRGBA(x, y, color.RGBA{})
+			}
+		}
+	}
+}
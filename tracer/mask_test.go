@@ -0,0 +1,75 @@
+package tracer
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+func TestMask(t *testing.T) {
+	r := image.Rect(0, 0, 10, 10)
+
+	m := NewMask(r)
+	m.IncludeOnly([]image.Rectangle{image.Rect(0, 0, 5, 5), image.Rect(8, 8, 10, 10)})
+	m.Exclude(image.Rect(1, 1, 3, 3))
+
+	maskIm := image.NewGray(r)
+	draw.Draw(maskIm, r, image.White, image.ZP, draw.Src)
+	maskIm.SetGray(4, 4, color.Gray{0})
+	m.ExcludeImage(maskIm)
+
+	for _, tc := range []struct {
+		x, y    int
+		allowed bool
+	}{
+		{0, 0, true},
+		{2, 2, false}, // excluded
+		{3, 3, true},
+		{4, 4, false}, // dark in mask image
+		{6, 6, false}, // not included
+		{9, 9, true},
+		{20, 20, true}, // outside of mask
+	} {
+		if a := m.Allowed(tc.x, tc.y); a != tc.allowed {
+			t.Errorf("Allowed(%d, %d) = %t, want %t", tc.x, tc.y, a, tc.allowed)
+		}
+	}
+}
+
+func TestNodeTracerMask(t *testing.T) {
+	red := color.RGBA{255, 0, 0, 255}
+	im := image.NewRGBA(image.Rect(0, 0, 40, 30))
+	draw.Draw(im, im.Rect, image.White, image.ZP, draw.Src)
+	draw.Draw(im, image.Rect(2, 3, 7, 8), image.NewUniform(red), image.ZP, draw.Src)
+	// a copy of the marker in a legend
+	draw.Draw(im, image.Rect(30, 22, 35, 27), image.NewUniform(red), image.ZP, draw.Src)
+
+	mask := NewMask(im.Rect)
+	mask.Exclude(image.Rect(28, 20, 40, 30))
+
+	tr, err := NewNode(NodeConfig{
+		Classes: []NodeClass{{
+			Detector: &ColorBlobDetector{
+				Color:            red,
+				MinColorAccuracy: 0.9,
+				MinAreaPx:        4,
+			},
+			StrengthThreshold: 0.9,
+			MaxCount:          10,
+		}},
+		Mask: mask,
+	}, im, t.Logf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.Find(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	nodes := tr.Graph().Nodes
+	if len(nodes) != 1 || nodes[0].Pt() != image.Pt(4, 5) {
+		t.Errorf("want single node at (4, 5), have %v", nodes)
+	}
+}
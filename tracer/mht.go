@@ -1,19 +1,22 @@
-// +build ignore
-
 package tracer
 
 import (
-	"fmt"
 	"image"
 	"math"
+	"sort"
 )
 
-const debugMHT = true
-
+// A pointHyp is a point and the hypotheses of the lines that it may
+// extend.
 type pointHyp struct {
 	p     image.Point
 	time  int
 	lines []*lineHyp
+	next  []*lineHyp // that extend lines, until p is decided
+
+	// How much the best continuation of each line through p turns,
+	// keyed by the head of the line. See PruneAt.
+	cont map[*lineHyp]float64
 
 	minScore     float64
 	minScoreLine *lineHyp
@@ -27,20 +30,33 @@ const (
 	stDone
 )
 
+// A lineHyp is a hypothetical line that ends in p and continues prev.
 type lineHyp struct {
-	p       *pointHyp
-	ewmaDir vec2
-	status  status
-	doneIdx int32 // index in multipleHypTracker.done
-	len     int32
-	head    *lineHyp
-	prev    *lineHyp
+	p        *pointHyp
+	ewmaDir  vec2
+	turn     float64 // angle between prev's direction and the step to p
+	cost     float64 // sum of turn along the line
+	score    float64 // see PruneAt
+	status   status
+	extended bool  // by a done line
+	doneIdx  int32 // index in multipleHypTracker.done
+	len      int32
+	head     *lineHyp
+	prev     *lineHyp
 }
 
 func (h *lineHyp) Src() image.Point { return h.head.p.p }
 func (h *lineHyp) Dst() image.Point { return h.p.p }
 func (h *lineHyp) Len() int         { return int(h.len) }
 
+// dir returns the direction that h is heading in.
+func (h *lineHyp) dir(ewmaPointThresh int) vec2 {
+	if h.Len() >= ewmaPointThresh {
+		return h.ewmaDir
+	}
+	return vec2{float64(h.Dst().X - h.Src().X), float64(h.Dst().Y - h.Src().Y)}
+}
+
 func (h *lineHyp) IsDead() bool {
 	for h != nil {
 		if h.status == stDead {
@@ -53,9 +69,21 @@ func (h *lineHyp) IsDead() bool {
 	return false
 }
 
+// A multipleHypTracker is like an lnnTracker, but instead of adding each
+// point to the closest run right away, it keeps the ways of extending
+// the runs until mhtLookahead steps later, and then picks the one whose
+// continuation turns the least.
+//
+// To keep the number of hypotheses in check, each point only extends the
+// cheapest hypothesis of each line through each earlier point, and only
+// for the few cheapest lines through that point. Once an
+// earlier point is decided, the hypotheses that extend it move over to
+// the one that was picked.
 type multipleHypTracker struct {
-	AllowedGapPx float64
-	DistPx       func(p1, p2 image.Point) float64
+	AllowedGapPx          float64
+	AllowedAngleOffsetRad float64
+	EWMAPointThresh       int
+	DistPx                func(p1, p2 image.Point) float64
 
 	done []*lineHyp
 
@@ -63,119 +91,189 @@ type multipleHypTracker struct {
 	wipPoints []*pointHyp
 }
 
-const ewmaPointThresh = 8
+// Number of steps to look ahead before deciding which line a point
+// belongs to.
+const mhtLookahead = 3
 
-func (t *multipleHypTracker) AddPoint(p image.Point, time int) {
+// Number of lines through an earlier point that a point may extend.
+const mhtMaxLinesPerPoint = 4
 
+func (t *multipleHypTracker) AddPoint(p image.Point, time int) {
 	ph := &pointHyp{p: p, time: time, minScore: math.Inf(1)}
-	t.wipPoints = append(t.wipPoints, ph)
 
 	// Initially, just record points as new lines
 	if time == 0 {
-		lh := new(lineHyp)
-		lh.p = ph
-		ph.lines = append(ph.lines, lh)
-		lh.status = stWIP
-		lh.doneIdx = -1
-		lh.len = 1
+		lh := &lineHyp{p: ph, doneIdx: -1, len: 1}
 		lh.head = lh
-		lh.prev = nil
+		ph.lines = append(ph.lines, lh)
 		t.wipLines = append(t.wipLines, lh)
+		t.wipPoints = append(t.wipPoints, ph)
 		return
 	}
 
 	// Afterwards, points need to be connected to existing lines
+	type viaKey struct {
+		p    *pointHyp
+		head *lineHyp
+	}
+	var via map[viaKey]*lineHyp
 	for _, lh := range t.wipLines {
-		if t.DistPx(lh.Dst(), p) <= t.AllowedGapPx {
-			lh2 := new(lineHyp)
-			lh2.p = ph
-			lh2.len = lh.len + 1
-			lh2.head = lh.head
-			lh2.prev = lh
-
-			if lh2.len == ewmaPointThresh {
-				lh2.ewmaDir.X = float64(p.X - lh.Src().X)
-				lh2.ewmaDir.Y = float64(p.Y - lh.Src().Y)
-			} else if lh2.len > ewmaPointThresh {
-				lh2.ewmaDir.X = alpha*float64(p.X-lh.Dst().X) + (1-alpha)*lh.ewmaDir.X
-				lh2.ewmaDir.Y = alpha*float64(p.Y-lh.Dst().Y) + (1-alpha)*lh.ewmaDir.Y
-			}
+		if lh.p.time >= time || t.DistPx(lh.Dst(), p) > t.AllowedGapPx {
+			continue
+		}
+		lh2 := &lineHyp{p: ph, doneIdx: -1}
+		if !t.extend(lh2, lh) {
+			continue
+		}
+		if via == nil {
+			via = make(map[viaKey]*lineHyp)
+		}
+		k := viaKey{lh.p, lh.head}
+		if o, ok := via[k]; !ok || lh2.cost < o.cost {
+			via[k] = lh2
+		}
+	}
+	if len(via) == 0 {
+		return // not near any line
+	}
 
-			t.wipLines = append(t.wipLines, lh2)
+	// Only keep the cheapest lines through each earlier point
+	byPoint := make(map[*pointHyp][]*lineHyp)
+	for k, lh := range via {
+		byPoint[k.p] = append(byPoint[k.p], lh)
+	}
+	for q, lines := range byPoint {
+		sort.Slice(lines, func(i, j int) bool { return lines[i].cost < lines[j].cost })
+		if len(lines) > mhtMaxLinesPerPoint {
+			lines = lines[:mhtMaxLinesPerPoint]
 		}
+		ph.lines = append(ph.lines, lines...)
+		q.next = append(q.next, lines...)
 	}
+	t.wipLines = append(t.wipLines, ph.lines...)
+	t.wipPoints = append(t.wipPoints, ph)
 }
 
-const mhtLookahead = 3
+// extend makes lh continue prev. It reports false if lh turns too far
+// away from prev.
+func (t *multipleHypTracker) extend(lh, prev *lineHyp) bool {
+	p, q := lh.Dst(), prev.Dst()
+	step := vec2{float64(p.X - q.X), float64(p.Y - q.Y)}
+	lh.turn = 0
+	if prev.len > 1 {
+		lh.turn = offAngle(prev.dir(t.EWMAPointThresh), step)
+		if lh.turn > t.AllowedAngleOffsetRad {
+			return false
+		}
+	}
+	lh.prev = prev
+	lh.head = prev.head
+	lh.len = prev.len + 1
+	lh.cost = prev.cost + lh.turn
+	if lh.Len() == t.EWMAPointThresh {
+		lh.ewmaDir.X = float64(p.X - lh.Src().X)
+		lh.ewmaDir.Y = float64(p.Y - lh.Src().Y)
+	} else if lh.Len() > t.EWMAPointThresh {
+		lh.ewmaDir.X = alpha*step.X + (1-alpha)*prev.ewmaDir.X
+		lh.ewmaDir.Y = alpha*step.Y + (1-alpha)*prev.ewmaDir.Y
+	}
+	return true
+}
 
+// PruneAt decides which lines the points from mhtLookahead or more steps
+// before time belong to.
 func (t *multipleHypTracker) PruneAt(time int) {
-	if time < mhtLookahead {
-		return
+	// Score the lines through each undecided point by how little their
+	// best continuation turns up to time. Continuations that stop short
+	// count as turning as much as allowed for the missing steps, so that
+	// dead ends are not preferred. Hypotheses of the same line through a
+	// point share their continuations, since those move over to the one
+	// that is picked.
+	for _, ph := range t.wipPoints {
+		ph.cont = make(map[*lineHyp]float64)
 	}
-
-	// Score different hypotheses
-	for _, lh := range t.wipLines {
-		end := lh.p.p
-		for lh != nil && lh.p.time > time-mhtLookahead {
-			lh = lh.prev
-		}
-		if lh == nil {
+	for _, end := range t.wipLines {
+		if end.IsDead() {
 			continue
 		}
-		//println(lh.len)
-		score := math.Pi
-		if lh.len > ewmaPointThresh {
-			avgDir := vec2{
-				X: float64(end.X - lh.p.p.X),
-				Y: float64(end.Y - lh.p.p.Y),
+		turns := float64(imax(0, time-end.p.time)) * t.AllowedAngleOffsetRad
+		for lh := end; lh != nil && lh.status == stWIP; lh = lh.prev {
+			if c, ok := lh.p.cont[lh.head]; !ok || turns < c {
+				lh.p.cont[lh.head] = turns
 			}
-			score = offAngle(avgDir, lh.prev.ewmaDir)
+			turns += lh.turn
 		}
-		ph := lh.p
-		if score < ph.minScore {
-			ph.minScore = score
-			ph.minScoreLine = lh
+	}
+	for _, ph := range t.wipPoints {
+		for _, lh := range ph.lines {
+			if lh.prev == nil {
+				continue
+			}
+			c, ok := ph.cont[lh.head]
+			if !ok {
+				c = float64(imax(0, time-ph.time)) * t.AllowedAngleOffsetRad
+			}
+			lh.score = (lh.turn + c) / float64(imax(1, time-lh.prev.p.time))
 		}
+		ph.cont = nil
 	}
 
-	stopIndex := len(t.wipPoints)
-	// Pick best line for each point
-	for i, ph := range t.wipPoints {
-		if ph.time <= time-mhtLookahead {
-			for _, lh := range ph.lines {
-				if lh != ph.minScoreLine {
-					lh.status = stDead
-				}
+	// Pick best line for each point, in the order they were added so
+	// that the lines they extend are decided first.
+	decided := 0
+	for _, ph := range t.wipPoints {
+		if ph.time > time-mhtLookahead {
+			break
+		}
+		decided++
+
+		for _, lh := range ph.lines {
+			if lh.prev != nil && (lh.prev.status != stDone || lh.prev.extended) {
+				continue // conflicts with an earlier decision
+			}
+			if lh.score < ph.minScore {
+				ph.minScore = lh.score
+				ph.minScoreLine = lh
+			}
+		}
+		for _, lh := range ph.lines {
+			if lh != ph.minScoreLine {
+				lh.status = stDead
 			}
-			ph.lines = nil
-			if ph.minScoreLine != nil {
-				if debugMHT && ph.minScoreLine.prev != nil {
-					fmt.Printf("attach %v to %v\n", ph.p, ph.minScoreLine.prev.p.p)
-				}
-				ph.minScoreLine.status = stDone
-
-				// Add to done slice, taking over a prefix's index
-				idx := int32(-1)
-				if ph.minScoreLine.prev != nil {
-					idx = ph.minScoreLine.prev.doneIdx
-				}
-				if idx == -1 {
-					idx = int32(len(t.done))
-					t.done = append(t.done, nil)
-				}
-				t.done[idx] = ph.minScoreLine
-				ph.minScoreLine.doneIdx = idx
-			} else {
-				if debugMHT {
-					fmt.Printf("throw away %v\n", ph.p)
-				}
+		}
+		ph.lines = nil
+
+		// Move the lines that extend ph over to the one it was added to.
+		lh := ph.minScoreLine
+		for _, next := range ph.next {
+			if lh == nil {
+				next.status = stDead
+			} else if next.prev != lh && !t.extend(next, lh) {
+				next.status = stDead
 			}
 		}
-		stopIndex = i
+		ph.next = nil
+		if lh == nil {
+			continue // throw away point
+		}
+		lh.status = stDone
+
+		// Add to done slice, taking over a prefix's index
+		idx := int32(-1)
+		if lh.prev != nil {
+			lh.prev.extended = true
+			idx = lh.prev.doneIdx
+		}
+		if idx == -1 {
+			idx = int32(len(t.done))
+			t.done = append(t.done, nil)
+		}
+		t.done[idx] = lh
+		lh.doneIdx = idx
 	}
 
 	// Clear decided points
-	t.wipPoints = t.wipPoints[stopIndex:]
+	t.wipPoints = t.wipPoints[decided:]
 
 	newWIP := make([]*lineHyp, 0, len(t.wipLines))
 	// Remove dead and done lines
@@ -187,26 +285,23 @@ func (t *multipleHypTracker) PruneAt(time int) {
 	t.wipLines = newWIP
 }
 
+// Finalize decides the lines of all remaining points.
 func (t *multipleHypTracker) Finalize() {
 	if len(t.wipPoints) == 0 {
 		return
 	}
-	time := t.wipPoints[0].time
-	for len(t.wipPoints) > 0 {
-		t.PruneAt(time)
-		time++
-	}
+	t.PruneAt(t.wipPoints[len(t.wipPoints)-1].time + mhtLookahead)
 }
 
 func (t *multipleHypTracker) Runs() []lineRun {
 	runs := make([]lineRun, len(t.done))
 	for i, lh := range t.done {
-		r := lineRun{SeenPoints: make([]image.Point, lh.Len())}
+		var r lineRun
 		for t := lh; t != nil; t = t.prev {
-			r.SeenPoints[t.len-1] = t.p.p
+			r.SeenPoints = append(r.SeenPoints, t.p.p)
 		}
-		if r.Src() != lh.Src() || r.Dst() != lh.Dst() {
-			panic("unexpected error")
+		for j, k := 0, len(r.SeenPoints)-1; j < k; j, k = j+1, k-1 {
+			r.SeenPoints[j], r.SeenPoints[k] = r.SeenPoints[k], r.SeenPoints[j]
 		}
 		runs[i] = r
 	}
@@ -1,5 +1,3 @@
-// +build ignore
-
 package tracer
 
 import (
@@ -10,7 +8,9 @@ import (
 
 func TestMultipleHypTracker(t *testing.T) {
 	tracker := multipleHypTracker{
-		AllowedGapPx: 10,
+		AllowedGapPx:          10,
+		AllowedAngleOffsetRad: 50 * math.Pi / 180,
+		EWMAPointThresh:       2,
 		DistPx: func(p1, p2 image.Point) float64 {
 			return math.Hypot(float64(p1.X-p2.X), float64(p1.Y-p2.Y))
 		},
@@ -66,5 +66,37 @@ func TestMultipleHypTracker(t *testing.T) {
 
 	mustEq(t, runs[0], p(0, 0), p(2, 3), p(4, 5), p(6, 7), p(8, 9), p(10, 11), p(12, 13), p(14, 15))
 	mustEq(t, runs[1], p(10, 2), p(8, 3), p(6, 5), p(4, 7), p(2, 9), p(0, 11), p(0, 13), p(0, 15))
-	mustEq(t, runs[2], p(100, 90), p(95, 90), p(85, 90), p(80, 90), p(75, 90))
+	mustEq(t, runs[2], p(100, 90), p(95, 90), p(90, 90), p(85, 90), p(80, 90), p(75, 90))
+}
+
+func TestMultipleHypTrackerDiagonal(t *testing.T) {
+	// Steps along these lines are exactly parallel, which offAngle must
+	// not turn into NaN costs.
+	tracker := multipleHypTracker{
+		AllowedGapPx:          10,
+		AllowedAngleOffsetRad: 30 * math.Pi / 180,
+		EWMAPointThresh:       2,
+		DistPx: func(p1, p2 image.Point) float64 {
+			return math.Hypot(float64(p1.X-p2.X), float64(p1.Y-p2.Y))
+		},
+	}
+
+	var want [2][]image.Point
+	for time := 0; time < 30; time++ {
+		a := image.Pt(3*time, 5*time)
+		b := image.Pt(200-5*time, 3*time)
+		tracker.AddPoint(a, time)
+		tracker.AddPoint(b, time)
+		tracker.PruneAt(time)
+		want[0] = append(want[0], a)
+		want[1] = append(want[1], b)
+	}
+	tracker.Finalize()
+	runs := tracker.Runs()
+
+	if len(runs) != 2 {
+		t.Fatalf("want 2 runs, have %d", len(runs))
+	}
+	mustEq(t, runs[0], want[0]...)
+	mustEq(t, runs[1], want[1]...)
 }
@@ -0,0 +1,129 @@
+package tracer
+
+import (
+	"image"
+	"math"
+	"sort"
+)
+
+// At most this many parallel links of each class are kept between two
+// nodes.
+const maxParallelLinks = 4
+
+// dedupeLinks returns links without those that follow the same line as a
+// shorter link of the same class between the same nodes, in either
+// direction. Links along lines that are separated by ParallelSepPx are
+// kept as parallel links.
+func (t *LinkTracer) dedupeLinks(links []Link) []Link {
+	type key struct {
+		a, b  int
+		class string
+	}
+	groups := make(map[key][]int)
+	for i, l := range links {
+		k := key{imin(l.Src, l.Dst), imax(l.Src, l.Dst), l.Class}
+		groups[k] = append(groups[k], i)
+	}
+
+	classes := t.c.lineClasses()
+	match := t.pixelMatcher(t.im, classes)
+	classIdx := make(map[string]int)
+	for i, cl := range classes {
+		classIdx[cl.Name] = i
+	}
+	b := t.im.Bounds()
+	nearNode := nodeRegions(t.g.Nodes, b, float64(t.c.NodeProximityPx))
+	near := func(p image.Point) bool {
+		return p.In(b) && nearNode[(p.Y-b.Min.Y)*b.Dx()+p.X-b.Min.X] >= 0
+	}
+
+	lens := make([]float64, len(links))
+	for i, l := range links {
+		lens[i] = polylineLen(l.Points)
+	}
+	keep := make([]bool, len(links))
+	for k, idx := range groups {
+		sort.SliceStable(idx, func(i, j int) bool { return lens[idx[i]] < lens[idx[j]] })
+		ci := classIdx[k.class]
+		onLine := func(x, y float64) bool {
+			p := image.Pt(int(math.Round(x)), int(math.Round(y)))
+			return p.In(b) && match(p.X, p.Y) == ci
+		}
+		var kept []int
+		for _, i := range idx {
+			if len(kept) == maxParallelLinks {
+				break
+			}
+			distinct := true
+			for _, j := range kept {
+				if !separated(links[i].Points, links[j].Points, onLine, near, t.c.ParallelSepPx) {
+					distinct = false
+					break
+				}
+			}
+			if distinct {
+				kept = append(kept, i)
+				keep[i] = true
+			}
+		}
+	}
+
+	var out []Link
+	for i, l := range links {
+		if keep[i] {
+			out = append(out, l)
+		}
+	}
+	return out
+}
+
+// polylineLen returns the length of the polyline through pts.
+func polylineLen(pts []image.Point) float64 {
+	var n float64
+	for i := 1; i < len(pts); i++ {
+		n += math.Hypot(float64(pts[i].X-pts[i-1].X), float64(pts[i].Y-pts[i-1].Y))
+	}
+	return n
+}
+
+// separated reports whether most points of a, not counting those near
+// nodes, are separated from the closest point of b by at least sepPx
+// pixels off of the line.
+func separated(a, b []image.Point, onLine func(x, y float64) bool, nearNode func(image.Point) bool, sepPx float64) bool {
+	n, sep := 0, 0
+	for _, p := range a {
+		if nearNode(p) {
+			continue
+		}
+		n++
+		var q image.Point
+		minDist := math.Inf(1)
+		for _, r := range b {
+			if d := math.Hypot(float64(r.X-p.X), float64(r.Y-p.Y)); d < minDist {
+				q, minDist = r, d
+			}
+		}
+		if longestOffLine(p, q, onLine) >= sepPx {
+			sep++
+		}
+	}
+	return n > 0 && 2*sep > n
+}
+
+// longestOffLine returns the length of the longest part of the segment
+// from p to q that is off of the line.
+func longestOffLine(p, q image.Point, onLine func(x, y float64) bool) float64 {
+	dx, dy := float64(q.X-p.X), float64(q.Y-p.Y)
+	steps := int(math.Ceil(math.Hypot(dx, dy) / widthStepPx))
+	longest, cur := 0, 0
+	for k := 1; k < steps; k++ {
+		t := float64(k) / float64(steps)
+		if onLine(float64(p.X)+t*dx, float64(p.Y)+t*dy) {
+			cur = 0
+			continue
+		}
+		cur++
+		longest = imax(longest, cur)
+	}
+	return float64(longest) * widthStepPx
+}
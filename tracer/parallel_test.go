@@ -0,0 +1,71 @@
+package tracer
+
+import (
+	"context"
+	"fmt"
+	"image/color"
+	"testing"
+)
+
+func TestParallelLinks(t *testing.T) {
+	// Two lines between A and B that bow apart, and a thick line between
+	// C and D that must not count twice.
+	dc := newLinkCanvas(500, 260)
+	dc.SetRGB(0, 0, 0)
+	dc.SetLineWidth(3)
+	dc.MoveTo(30, 60)
+	dc.LineTo(80, 40)
+	dc.LineTo(220, 40)
+	dc.LineTo(270, 60)
+	dc.MoveTo(30, 60)
+	dc.LineTo(80, 80)
+	dc.LineTo(220, 80)
+	dc.LineTo(270, 60)
+	dc.Stroke()
+	dc.SetLineWidth(8)
+	dc.DrawLine(30, 200, 270, 200)
+	dc.Stroke()
+
+	g := XYGraph{Nodes: []Node{
+		{X: 30, Y: 60},   // A
+		{X: 270, Y: 60},  // B
+		{X: 30, Y: 200},  // C
+		{X: 270, Y: 200}, // D
+	}}
+	for _, method := range []LinkMethod{LineRuns, PathSearch, Skeleton} {
+		for _, sep := range []float64{0, 2} {
+			if sep == 0 && method == LineRuns {
+				continue // keeps every run that it traces
+			}
+			tr := NewLink(LinkConfig{
+				Method:               method,
+				Color:                color.Black,
+				MinColorAccuracy:     0.6,
+				MinWidthPx:           1,
+				AllowedGapPx:         3,
+				NodeProximityPx:      8,
+				ExpectedDirectionDeg: 45,
+				ParallelSepPx:        sep,
+				NarrowRunSteps:       true,
+				MaxLinkLenPx:         300,
+				OffLineCost:          10,
+				MaxPathCost:          1.5,
+			}, dc.Image(), &g, t.Logf)
+			if err := tr.Find(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+
+			what := fmt.Sprintf("method %d, sep %v", method, sep)
+			want := map[[2]int]int{{0, 1}: 2, {2, 3}: 1}
+			if sep == 0 {
+				want[[2]int{0, 1}] = 1
+			}
+			have := checkLinks(t, what, tr.Graph().Links, [2]int{0, 1}, [2]int{2, 3})
+			for k, n := range want {
+				if len(have[k]) != n {
+					t.Errorf("%s: want %d links %v, have %d", what, n, k, len(have[k]))
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,303 @@
+package tracer
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"image"
+	"math"
+)
+
+// findPathLinks links each pair of nodes within MaxLinkLenPx of each
+// other if the cheapest path between them costs at most MaxPathCost
+// times their distance and leaves lines for at most AllowedGapPx pixels
+// at a time.
+//
+// Each pixel of a path costs 1 if it is on a line and OffLineCost
+// otherwise. Paths may not pass by other nodes, so a line through
+// several nodes only links neighbors.
+//
+// If ParallelSepPx is positive, each pair is searched again with the
+// lines of the paths found so far counting as off of lines, to find
+// parallel links.
+func (t *LinkTracer) findPathLinks(ctx context.Context) error {
+	b := t.im.Bounds()
+	w, h := b.Dx(), b.Dy()
+	lc := &t.c
+
+	classes := lc.lineClasses()
+	pixels, bridged, err := t.linePixels(ctx, t.lineMatcher(t.im, classes), len(classes))
+	if err != nil {
+		return err
+	}
+
+	nearNode := nodeRegions(t.g.Nodes, b, float64(lc.NodeProximityPx))
+
+	type pair struct{ src, dst int }
+	var pairs []pair
+	for i, n := range t.g.Nodes {
+		for j := i + 1; j < len(t.g.Nodes); j++ {
+			m := t.g.Nodes[j]
+			if math.Hypot(n.X-m.X, n.Y-m.Y) <= lc.MaxLinkLenPx {
+				pairs = append(pairs, pair{i, j})
+			}
+		}
+	}
+	t.log("searching for paths between %d pairs of nodes", len(pairs))
+
+	for ci, cl := range classes {
+		onLine := newBitmap2(w, h)
+		for _, p := range pixels[ci] {
+			onLine.Set(p.X-b.Min.X, p.Y-b.Min.Y)
+		}
+		t.log("%d points that possibly belong to %s lines", onLine.Count(), className(cl.Name))
+
+		lineAt := func(x, y float64) bool {
+			p := image.Pt(int(math.Round(x)), int(math.Round(y)))
+			return p.In(b) && onLine.Get(p.X-b.Min.X, p.Y-b.Min.Y)
+		}
+		near := func(p image.Point) bool {
+			return p.In(b) && nearNode[(p.Y-b.Min.Y)*w+p.X-b.Min.X] >= 0
+		}
+
+		paths := make([][][]image.Point, len(pairs))
+		stage := fmt.Sprintf("searching for paths along %s lines", className(cl.Name))
+		err = forEach(ctx, len(pairs), stage, lc.Progress, func(i int) {
+			s := pathSearch{
+				bounds:      b,
+				onLine:      onLine,
+				nearNode:    nearNode,
+				offLineCost: lc.OffLineCost,
+			}
+			src, dst := pairs[i].src, pairs[i].dst
+			for len(paths[i]) < maxParallelLinks {
+				p := s.find(t.g.Nodes, src, dst, lc.MaxPathCost)
+				if p == nil || s.longestGap(p, src, dst) > lc.AllowedGapPx {
+					break
+				}
+				paths[i] = append(paths[i], p)
+				if lc.ParallelSepPx <= 0 {
+					break
+				}
+				s.take(p, math.Max(1, lineWidth(p, lineAt, near)))
+			}
+		})
+		if err != nil {
+			return err
+		}
+		for i, ps := range paths {
+			for _, p := range ps {
+				t.g.Links = append(t.g.Links, Link{
+					Src:    pairs[i].src,
+					Dst:    pairs[i].dst,
+					Class:  cl.Name,
+					Dashed: isDashed(p, bridged[ci], b),
+					Points: p,
+				})
+			}
+		}
+	}
+	return nil
+}
+
+// nodeRegions returns the index of the node within r pixels of each
+// pixel of b, or -1 if there is none. Pixels are indexed row by row from
+// b.Min.
+func nodeRegions(nodes []Node, b image.Rectangle, r float64) []int32 {
+	w := b.Dx()
+	nearNode := make([]int32, w*b.Dy())
+	for i := range nearNode {
+		nearNode[i] = -1
+	}
+	for ni, n := range nodes {
+		box := squareAround(n.X, n.Y, r).Intersect(b)
+		for y := box.Min.Y; y < box.Max.Y; y++ {
+			for x := box.Min.X; x < box.Max.X; x++ {
+				i := (y-b.Min.Y)*w + x - b.Min.X
+				if nearNode[i] < 0 && math.Hypot(float64(x)-n.X, float64(y)-n.Y) <= r {
+					nearNode[i] = int32(ni)
+				}
+			}
+		}
+	}
+	return nearNode
+}
+
+type pathSearch struct {
+	bounds      image.Rectangle
+	onLine      *bitmap2 // relative to bounds.Min
+	nearNode    []int32  // index of node whose marker covers each pixel, or -1
+	offLineCost float64
+
+	// Pixels of lines that were already followed, which count as off of
+	// lines.
+	taken     *bitmap2 // relative to takenRect.Min
+	takenRect image.Rectangle
+}
+
+// take marks the pixels within r of path as taken.
+func (s *pathSearch) take(path []image.Point, r float64) {
+	ri := int(math.Ceil(r))
+	rect := s.takenRect
+	for _, p := range path {
+		rect = rect.Union(image.Rectangle{p, p.Add(image.Pt(1, 1))}.Inset(-ri))
+	}
+	rect = rect.Intersect(s.bounds)
+	if rect != s.takenRect {
+		taken := newBitmap2(rect.Dx(), rect.Dy())
+		for y := s.takenRect.Min.Y; y < s.takenRect.Max.Y; y++ {
+			for x := s.takenRect.Min.X; x < s.takenRect.Max.X; x++ {
+				if s.taken.Get(x-s.takenRect.Min.X, y-s.takenRect.Min.Y) {
+					taken.Set(x-rect.Min.X, y-rect.Min.Y)
+				}
+			}
+		}
+		s.taken, s.takenRect = taken, rect
+	}
+	for _, p := range path {
+		for y := p.Y - ri; y <= p.Y+ri; y++ {
+			for x := p.X - ri; x <= p.X+ri; x++ {
+				if image.Pt(x, y).In(rect) && math.Hypot(float64(x-p.X), float64(y-p.Y)) <= r {
+					s.taken.Set(x-rect.Min.X, y-rect.Min.Y)
+				}
+			}
+		}
+	}
+}
+
+type pathItem struct {
+	i int     // index in search grid
+	f float64 // cost so far plus estimate
+}
+
+type pathHeap []pathItem
+
+func (h pathHeap) Len() int            { return len(h) }
+func (h pathHeap) Less(i, j int) bool  { return h[i].f < h[j].f }
+func (h pathHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *pathHeap) Push(x interface{}) { *h = append(*h, x.(pathItem)) }
+
+func (h *pathHeap) Pop() interface{} {
+	t := (*h)[len(*h)-1]
+	*h = (*h)[:len(*h)-1]
+	return t
+}
+
+// find returns the cheapest path from nodes[src] to nodes[dst] using A*,
+// or nil if it costs more than maxCost per pixel of distance between the
+// nodes.
+func (s *pathSearch) find(nodes []Node, src, dst int, maxCost float64) []image.Point {
+	from, to := nodes[src].Pt(), nodes[dst].Pt()
+	dist := math.Hypot(float64(to.X-from.X), float64(to.Y-from.Y))
+	bound := maxCost * math.Max(dist, 1)
+
+	// A path cannot leave the ellipse with foci at the nodes whose
+	// points are bound away from both, so only search its bounding box.
+	margin := int(math.Ceil((bound-dist)/2)) + 1
+	grid := image.Rectangle{from, to}.Canon().Inset(-margin).Intersect(s.bounds)
+	if !from.In(grid) || !to.In(grid) {
+		return nil
+	}
+	gw, gh := grid.Dx(), grid.Dy()
+	cost := make([]float64, gw*gh)
+	for i := range cost {
+		cost[i] = math.Inf(1)
+	}
+	prev := make([]int32, gw*gh)
+	done := newBitmap2(gw, gh)
+
+	idx := func(p image.Point) int { return (p.Y-grid.Min.Y)*gw + p.X - grid.Min.X }
+	pt := func(i int) image.Point { return image.Pt(i%gw+grid.Min.X, i/gw+grid.Min.Y) }
+	estimate := func(p image.Point) float64 {
+		return math.Hypot(float64(to.X-p.X), float64(to.Y-p.Y))
+	}
+
+	start, goal := idx(from), idx(to)
+	cost[start] = 0
+	prev[start] = -1
+	h := pathHeap{{start, estimate(from)}}
+	for h.Len() > 0 {
+		it := heap.Pop(&h).(pathItem)
+		if it.f > bound {
+			return nil
+		}
+		p := pt(it.i)
+		if done.Get(p.X-grid.Min.X, p.Y-grid.Min.Y) {
+			continue
+		}
+		done.Set(p.X-grid.Min.X, p.Y-grid.Min.Y)
+		if it.i == goal {
+			break
+		}
+		for dy := -1; dy <= 1; dy++ {
+			for dx := -1; dx <= 1; dx++ {
+				q := p.Add(image.Pt(dx, dy))
+				if (dx == 0 && dy == 0) || !q.In(grid) {
+					continue
+				}
+				step := s.stepCost(q, src, dst)
+				if step < 0 {
+					continue
+				}
+				if dx != 0 && dy != 0 {
+					step *= math.Sqrt2
+				}
+				qi := idx(q)
+				if c := cost[it.i] + step; c < cost[qi] {
+					cost[qi] = c
+					prev[qi] = int32(it.i)
+					heap.Push(&h, pathItem{qi, c + estimate(q)})
+				}
+			}
+		}
+	}
+	if math.IsInf(cost[goal], 1) || cost[goal] > bound {
+		return nil
+	}
+
+	var path []image.Point
+	for i := goal; i >= 0; i = int(prev[i]) {
+		path = append(path, pt(i))
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path
+}
+
+// longestGap returns the largest number of consecutive pixels of path
+// that are off of lines.
+func (s *pathSearch) longestGap(path []image.Point, src, dst int) int {
+	longest, cur := 0, 0
+	for _, p := range path {
+		if s.stepCost(p, src, dst) == 1 {
+			cur = 0
+			continue
+		}
+		cur++
+		if cur > longest {
+			longest = cur
+		}
+	}
+	return longest
+}
+
+// stepCost returns the cost of moving onto q, or -1 if q is part of a
+// node other than src and dst.
+func (s *pathSearch) stepCost(q image.Point, src, dst int) float64 {
+	x, y := q.X-s.bounds.Min.X, q.Y-s.bounds.Min.Y
+	switch n := s.nearNode[y*s.bounds.Dx()+x]; {
+	case n == int32(src) || n == int32(dst):
+		// Lines are covered by markers near their ends.
+		return 1
+	case n >= 0:
+		return -1
+	}
+	if s.taken != nil && q.In(s.takenRect) && s.taken.Get(q.X-s.takenRect.Min.X, q.Y-s.takenRect.Min.Y) {
+		return s.offLineCost
+	}
+	if s.onLine.Get(x, y) {
+		return 1
+	}
+	return s.offLineCost
+}
@@ -0,0 +1,69 @@
+package tracer
+
+import (
+	"context"
+	"image/color"
+	"testing"
+)
+
+func TestPathSearchLinks(t *testing.T) {
+	dc := newLinkCanvas(160, 140)
+	dc.SetRGB(0, 0, 0)
+	dc.SetLineWidth(3)
+	// one line through A, B, and C
+	dc.DrawLine(20, 50, 140, 50)
+	dc.Stroke()
+	// a bent line from B to D with a small gap
+	dc.MoveTo(80, 50)
+	dc.LineTo(110, 80)
+	dc.LineTo(80, 110)
+	dc.Stroke()
+	dc.SetRGB(1, 1, 1)
+	dc.DrawRectangle(95, 90, 4, 4)
+	dc.Fill()
+
+	g := XYGraph{Nodes: []Node{
+		{X: 20, Y: 50},  // A
+		{X: 80, Y: 50},  // B
+		{X: 140, Y: 50}, // C
+		{X: 80, Y: 110}, // D
+		{X: 20, Y: 110}, // E, not linked
+	}}
+
+	tr := NewLink(LinkConfig{
+		Method:           PathSearch,
+		Color:            color.Black,
+		MinColorAccuracy: 0.6,
+		MinWidthPx:       1,
+		AllowedGapPx:     6,
+		NodeProximityPx:  8,
+		MaxLinkLenPx:     150,
+		OffLineCost:      10,
+		MaxPathCost:      2,
+	}, dc.Image(), &g, t.Logf)
+	if err := tr.Find(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	checkLinks(t, "links", tr.Graph().Links, [2]int{0, 1}, [2]int{1, 2}, [2]int{1, 3})
+	for _, l := range tr.Graph().Links {
+		if l.Points[0] != g.Nodes[l.Src].Pt() || l.Points[len(l.Points)-1] != g.Nodes[l.Dst].Pt() {
+			t.Errorf("link %d-%d: path goes from %v to %v", l.Src, l.Dst, l.Points[0], l.Points[len(l.Points)-1])
+		}
+	}
+
+	// The path from B to D follows the bend.
+	for _, l := range tr.Graph().Links {
+		if l.Src == 1 && l.Dst == 3 {
+			bent := false
+			for _, p := range l.Points {
+				if p.X >= 105 {
+					bent = true
+				}
+			}
+			if !bent {
+				t.Errorf("want path from B to D to follow the line, have %v", l.Points)
+			}
+		}
+	}
+}
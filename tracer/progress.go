@@ -0,0 +1,67 @@
+package tracer
+
+import (
+	"context"
+	"runtime"
+	"sync"
+)
+
+// Progress describes how far along a tracer is in one of its stages.
+type Progress struct {
+	Stage string // e.g. "scoring candidate transit nodes"
+	Done  int
+	Total int // or UnknownTotal
+}
+
+// UnknownTotal is the Total of stages whose length is not known.
+const UnknownTotal = 0
+
+// A ProgressFunc receives progress updates. It is never called
+// concurrently.
+type ProgressFunc func(Progress)
+
+func (f ProgressFunc) report(stage string, done, total int) {
+	if f != nil {
+		f(Progress{Stage: stage, Done: done, Total: total})
+	}
+}
+
+// forEach calls fn(i) for each i in [0, n) on GOMAXPROCS workers and
+// reports progress on stage as the calls finish. If ctx is cancelled, it
+// stops early and returns ctx.Err().
+func forEach(ctx context.Context, n int, stage string, progress ProgressFunc, fn func(i int)) error {
+	work := make(chan int)
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		done int
+	)
+	progress.report(stage, 0, n)
+	for w := 0; w < runtime.GOMAXPROCS(0); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				fn(i)
+				mu.Lock()
+				done++
+				progress.report(stage, done, n)
+				mu.Unlock()
+			}
+		}()
+	}
+
+	var err error
+feed:
+	for i := 0; i < n; i++ {
+		select {
+		case work <- i:
+		case <-ctx.Done():
+			err = ctx.Err()
+			break feed
+		}
+	}
+	close(work)
+	wg.Wait()
+	return err
+}
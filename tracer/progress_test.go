@@ -0,0 +1,38 @@
+package tracer
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+)
+
+func TestForEach(t *testing.T) {
+	var sum int64
+	var last Progress
+	err := forEach(context.Background(), 100, "adding", func(p Progress) { last = p }, func(i int) {
+		atomic.AddInt64(&sum, int64(i))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum != 4950 {
+		t.Errorf("want sum 4950, have %d", sum)
+	}
+	if want := (Progress{"adding", 100, 100}); last != want {
+		t.Errorf("want last progress %v, have %v", want, last)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var calls int64
+	err = forEach(ctx, 1000, "cancelling", nil, func(i int) {
+		if atomic.AddInt64(&calls, 1) == 10 {
+			cancel()
+		}
+	})
+	if err != context.Canceled {
+		t.Errorf("want context.Canceled, have %v", err)
+	}
+	if calls >= 1000 {
+		t.Errorf("want early stop, have %d calls", calls)
+	}
+}
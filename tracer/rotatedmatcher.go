@@ -0,0 +1,86 @@
+package tracer
+
+import (
+	"image"
+	"math"
+
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/math/f64"
+)
+
+// A RotatedBlobMatcher is a BlobMatcher that searches over several blob
+// orientations.
+type RotatedBlobMatcher interface {
+	BlobMatcher
+
+	// MatchAngle returns the rotation (in degrees, counter-clockwise)
+	// of the best match at (x, y).
+	MatchAngle(x, y int, im *image.RGBA) float64
+}
+
+// RotatedIconMatcher matches an icon that may be drawn at different angles,
+// such as squares, diamonds, or arrows.
+type RotatedIconMatcher struct {
+	iconVariants
+	angles []float64
+}
+
+var (
+	_ RotatedBlobMatcher = &RotatedIconMatcher{}
+	_ MetricMatcher      = &RotatedIconMatcher{}
+)
+
+// NewRotatedIconMatcher returns a matcher that tries the icon at each
+// of anglesDeg.
+func NewRotatedIconMatcher(im image.Image, anglesDeg []float64) *RotatedIconMatcher {
+	if len(anglesDeg) == 0 {
+		panic("no angles to match")
+	}
+	m := &RotatedIconMatcher{angles: append([]float64(nil), anglesDeg...)}
+	for _, a := range anglesDeg {
+		m.ms = append(m.ms, NewIconMatcher(rotateIcon(im, a)))
+	}
+	return m
+}
+
+func (m *RotatedIconMatcher) Angles() []float64 {
+	return append([]float64(nil), m.angles...)
+}
+
+func (m *RotatedIconMatcher) WithMetric(mm MatchMetric) BlobMatcher {
+	return &RotatedIconMatcher{m.iconVariants.withMetric(mm), m.angles}
+}
+
+func (m *RotatedIconMatcher) MatchAngle(x, y int, im *image.RGBA) float64 {
+	i, _ := m.bestMatch(x, y, im)
+	return m.angles[i]
+}
+
+// rotateIcon rotates im counter-clockwise about its center.
+// The output is large enough to hold the whole rotated icon and
+// pixels not covered by it are transparent.
+func rotateIcon(im image.Image, deg float64) *image.RGBA {
+	b := im.Bounds()
+	rad := deg * math.Pi / 180
+	// Image y points down, so a counter-clockwise rotation on screen
+	// uses the negated angle.
+	sin, cos := math.Sincos(-rad)
+
+	w := float64(b.Dx())
+	h := float64(b.Dy())
+	w2 := math.Abs(w*cos) + math.Abs(h*sin)
+	h2 := math.Abs(w*sin) + math.Abs(h*cos)
+	out := image.NewRGBA(image.Rect(0, 0, int(math.Round(w2)), int(math.Round(h2))))
+
+	srcCX := float64(b.Min.X) + w/2
+	srcCY := float64(b.Min.Y) + h/2
+	dstCX := float64(out.Rect.Dx()) / 2
+	dstCY := float64(out.Rect.Dy()) / 2
+
+	s2d := f64.Aff3{
+		cos, -sin, dstCX - (cos*srcCX - sin*srcCY),
+		sin, cos, dstCY - (sin*srcCX + cos*srcCY),
+	}
+	xdraw.NearestNeighbor.Transform(out, s2d, im, b, xdraw.Src, nil)
+	return out
+}
@@ -0,0 +1,80 @@
+package tracer
+
+import (
+	"context"
+	"image"
+	"testing"
+)
+
+func TestRotateIcon(t *testing.T) {
+	icon := bitmapImage{
+		{1, 1, 1},
+		{0, 0, 1},
+		{0, 0, 0},
+	}
+
+	// icon rotated by 90 degrees counter-clockwise
+	want := copyToRGBA(bitmapImage{
+		{1, 1, 0},
+		{1, 0, 0},
+		{1, 0, 0},
+	})
+
+	have := rotateIcon(icon, 90)
+	if have.Rect != want.Rect {
+		t.Fatalf("bounds differ: want %v, have %v", want.Rect, have.Rect)
+	}
+	if s := imToString(have); s != imToString(want) {
+		t.Errorf("bad rotation:\nwant:\n%s\nhave:\n%s", imToString(want), s)
+	}
+}
+
+func TestRotatedIconMatcher(t *testing.T) {
+	icon := bitmapImage{
+		{1, 1, 1},
+		{0, 0, 1},
+		{0, 0, 0},
+	}
+
+	bim := bitmapImage{
+		{0, 0, 0, 0, 0, 0, 0},
+		{0, 0, 0, 0, 0, 0, 0},
+		{0, 0, 0, 0, 0, 0, 0},
+		{0, 0, 0, 0, 0, 0, 0},
+		{0, 0, 1, 0, 0, 0, 0},
+		{0, 0, 1, 1, 1, 0, 0},
+		{0, 0, 0, 0, 0, 0, 0},
+	}
+
+	im := copyToRGBA(bim)
+	m := NewRotatedIconMatcher(icon, []float64{0, 90, 180, 270})
+
+	loc := image.Pt(3, 4)
+	if st := m.MatchStrength(loc.X, loc.Y, im); st != 1 {
+		t.Errorf("failed to find icon at %v: strength is %f", loc, st)
+	}
+	if a := m.MatchAngle(loc.X, loc.Y, im); a != 180 {
+		t.Errorf("matched at angle %f, want 180", a)
+	}
+
+	tr, err := NewNode(NodeConfig{Classes: []NodeClass{{Matcher: m, StrengthThreshold: 0.9, MaxCount: 1}}}, copyToRGBA(bim), t.Logf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.Find(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if ns := tr.Graph().Nodes; len(ns) != 1 || ns[0].Angle != 180 {
+		t.Errorf("want one node at angle 180, have %v", ns)
+	}
+
+	m.EraseMatch(loc.X, loc.Y, im)
+	if st := m.MatchStrength(loc.X, loc.Y, im); st == 1 {
+		t.Errorf("failed to erase icon at %v; image:\n%s", loc, imToString(im))
+	}
+	for _, p := range []image.Point{{2, 4}, {2, 5}, {3, 5}, {4, 5}} {
+		if c := im.RGBAAt(p.X, p.Y); c.A != 0 {
+			t.Errorf("pixel %v not erased: %v", p, c)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package tracer
+
+import (
+	"image"
+	"math"
+)
+
+// fitRoutes sets the Route of links to a polyline from their Src node
+// to their Dst node that follows their Points to within
+// RouteTolerancePx, and marks the links whose Points cross the seam.
+func (t *LinkTracer) fitRoutes(links []Link) {
+	for i := range links {
+		l := &links[i]
+		pts := make([]image.Point, 0, len(l.Points)+2)
+		pts = append(pts, t.g.Nodes[l.Src].Pt())
+		pts = append(pts, l.Points...)
+		pts = append(pts, t.g.Nodes[l.Dst].Pt())
+		pts, l.CrossesSeam = unwrapX(pts, t.g.Bounds.Dx())
+		l.Route = simplifyPolyline(pts, t.c.RouteTolerancePx)
+	}
+}
+
+// unwrapX adds multiples of w to the X of pts so that no step between
+// them goes more than halfway across, as steps that wrap around the
+// left and right edges of an image w pixels wide do. It reports whether
+// any step wrapped.
+func unwrapX(pts []image.Point, w int) ([]image.Point, bool) {
+	out := make([]image.Point, len(pts))
+	off := 0
+	wrapped := false
+	for i, p := range pts {
+		if i > 0 {
+			switch dx := p.X + off - out[i-1].X; {
+			case dx > w/2:
+				off -= w
+				wrapped = true
+			case dx < -w/2:
+				off += w
+				wrapped = true
+			}
+		}
+		out[i] = image.Pt(p.X+off, p.Y)
+	}
+	return out, wrapped
+}
+
+// simplifyPolyline returns the points of pts that the Douglas-Peucker
+// algorithm keeps so that every point is within tol pixels of the
+// result. The ends are always kept, and repeated points are dropped.
+func simplifyPolyline(pts []image.Point, tol float64) []image.Point {
+	var dedup []image.Point
+	for i, p := range pts {
+		if i == 0 || p != pts[i-1] {
+			dedup = append(dedup, p)
+		}
+	}
+	if len(dedup) <= 2 {
+		return dedup
+	}
+
+	keep := make([]bool, len(dedup))
+	keep[0], keep[len(dedup)-1] = true, true
+	type span struct{ a, b int }
+	stack := []span{{0, len(dedup) - 1}}
+	for len(stack) > 0 {
+		s := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		far, farDist := -1, tol
+		for i := s.a + 1; i < s.b; i++ {
+			if d := segmentDist(dedup[i], dedup[s.a], dedup[s.b]); d > farDist {
+				far, farDist = i, d
+			}
+		}
+		if far >= 0 {
+			keep[far] = true
+			stack = append(stack, span{s.a, far}, span{far, s.b})
+		}
+	}
+
+	var out []image.Point
+	for i, p := range dedup {
+		if keep[i] {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// segmentDist returns the distance from p to the segment from a to b.
+func segmentDist(p, a, b image.Point) float64 {
+	dx, dy := float64(b.X-a.X), float64(b.Y-a.Y)
+	px, py := float64(p.X-a.X), float64(p.Y-a.Y)
+	if l2 := dx*dx + dy*dy; l2 > 0 {
+		t := math.Max(0, math.Min(1, (px*dx+py*dy)/l2))
+		px, py = px-t*dx, py-t*dy
+	}
+	return math.Hypot(px, py)
+}
@@ -0,0 +1,65 @@
+package tracer
+
+import (
+	"image"
+	"testing"
+)
+
+func TestSimplifyPolyline(t *testing.T) {
+	// An arc sampled with some jitter, followed by a straight part
+	var pts []image.Point
+	for x := 0; x <= 40; x++ {
+		y := x * (40 - x) / 20
+		if x%3 == 0 {
+			y++
+		}
+		pts = append(pts, image.Pt(x, y))
+	}
+	for x := 41; x <= 100; x++ {
+		pts = append(pts, image.Pt(x, 0))
+	}
+	pts = append(pts, image.Pt(100, 0)) // repeated
+
+	route := simplifyPolyline(pts, 2)
+	if route[0] != pts[0] || route[len(route)-1] != pts[len(pts)-1] {
+		t.Errorf("route %v does not keep the ends", route)
+	}
+	if len(route) < 4 || len(route) > 12 {
+		t.Errorf("want a few points along the arc, have %v", route)
+	}
+	for _, p := range pts {
+		min := 1e9
+		for i := 1; i < len(route); i++ {
+			if d := segmentDist(p, route[i-1], route[i]); d < min {
+				min = d
+			}
+		}
+		if min > 2 {
+			t.Errorf("point %v is %f from route %v", p, min, route)
+		}
+	}
+	for i := 1; i < len(route); i++ {
+		if route[i] == route[i-1] {
+			t.Errorf("route %v repeats points", route)
+		}
+	}
+}
+
+func TestUnwrapX(t *testing.T) {
+	pts := []image.Point{{10, 5}, {4, 6}, {1, 6}, {97, 7}, {90, 8}}
+	have, wrapped := unwrapX(pts, 100)
+	want := []image.Point{{10, 5}, {4, 6}, {1, 6}, {-3, 7}, {-10, 8}}
+	if !wrapped {
+		t.Error("want wrapped")
+	}
+	for i := range want {
+		if have[i] != want[i] {
+			t.Errorf("want %v, have %v", want, have)
+			break
+		}
+	}
+
+	if _, wrapped := unwrapX(pts[:3], 100); wrapped {
+		t.Error("want not wrapped")
+	}
+}
@@ -0,0 +1,108 @@
+package tracer
+
+import (
+	"image"
+	"math"
+
+	xdraw "golang.org/x/image/draw"
+)
+
+// A ScaledBlobMatcher is a BlobMatcher that searches over several blob sizes.
+type ScaledBlobMatcher interface {
+	BlobMatcher
+
+	// MatchScale returns the scale of the best match at (x, y).
+	MatchScale(x, y int, im *image.RGBA) float64
+}
+
+// ScaledIconMatcher matches an icon that may appear at a different size
+// in the image than in the icon file.
+type ScaledIconMatcher struct {
+	iconVariants
+	scales []float64
+}
+
+var (
+	_ ScaledBlobMatcher = &ScaledIconMatcher{}
+	_ MetricMatcher     = &ScaledIconMatcher{}
+)
+
+// NewScaledIconMatcher returns a matcher that tries the icon at scales
+// minScale, minScale+step, ..., maxScale.
+func NewScaledIconMatcher(im image.Image, minScale, maxScale, step float64) *ScaledIconMatcher {
+	if step <= 0 || maxScale < minScale {
+		panic("invalid scale range")
+	}
+	m := new(ScaledIconMatcher)
+	// Add a small epsilon so that maxScale is included despite rounding.
+	for s := minScale; s <= maxScale+step*1e-6; s += step {
+		m.scales = append(m.scales, s)
+		m.ms = append(m.ms, NewIconMatcher(scaleIcon(im, s)))
+	}
+	return m
+}
+
+func (m *ScaledIconMatcher) Scales() []float64 {
+	return append([]float64(nil), m.scales...)
+}
+
+func (m *ScaledIconMatcher) WithMetric(mm MatchMetric) BlobMatcher {
+	return &ScaledIconMatcher{m.iconVariants.withMetric(mm), m.scales}
+}
+
+func (m *ScaledIconMatcher) MatchScale(x, y int, im *image.RGBA) float64 {
+	i, _ := m.bestMatch(x, y, im)
+	return m.scales[i]
+}
+
+// iconVariants matches the best of several transformed copies of an icon.
+type iconVariants struct {
+	ms []*IconMatcher
+}
+
+func (m *iconVariants) withMetric(mm MatchMetric) iconVariants {
+	ms := make([]*IconMatcher, len(m.ms))
+	for i := range m.ms {
+		ms[i] = m.ms[i].WithMetric(mm).(*IconMatcher)
+	}
+	return iconVariants{ms}
+}
+
+func (m *iconVariants) bestMatch(x, y int, im *image.RGBA) (int, float64) {
+	best := -1
+	bestScore := math.Inf(-1)
+	for i, im2 := range m.ms {
+		if score := im2.MatchStrength(x, y, im); score > bestScore {
+			best = i
+			bestScore = score
+		}
+	}
+	return best, bestScore
+}
+
+func (m *iconVariants) MatchStrength(x, y int, im *image.RGBA) float64 {
+	_, score := m.bestMatch(x, y, im)
+	return score
+}
+
+func (m *iconVariants) EraseMatch(x, y int, im *image.RGBA) {
+	i, _ := m.bestMatch(x, y, im)
+	m.ms[i].EraseMatch(x, y, im)
+}
+
+func scaleIcon(im image.Image, s float64) *image.RGBA {
+	b := im.Bounds()
+	w := int(math.Round(float64(b.Dx()) * s))
+	h := int(math.Round(float64(b.Dy()) * s))
+	if w < 1 {
+		w = 1
+	}
+	if h < 1 {
+		h = 1
+	}
+	out := image.NewRGBA(image.Rect(0, 0, w, h))
+	// Nearest neighbor keeps transparent pixels fully transparent so
+	// that the icon mask is preserved.
+	xdraw.NearestNeighbor.Scale(out, out.Rect, im, b, xdraw.Src, nil)
+	return out
+}
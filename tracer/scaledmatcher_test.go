@@ -0,0 +1,62 @@
+package tracer
+
+import (
+	"context"
+	"image"
+	"testing"
+)
+
+func TestScaledIconMatcher(t *testing.T) {
+	icon := bitmapImage{
+		{0, 1, 0},
+		{1, 1, 0},
+		{0, 0, 1},
+	}
+
+	// icon at 2x, with top-left corner at (2, 1)
+	bim := bitmapImage{
+		{0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+		{0, 0, 0, 0, 1, 1, 0, 0, 0, 0},
+		{0, 0, 0, 0, 1, 1, 0, 0, 0, 0},
+		{0, 0, 1, 1, 1, 1, 0, 0, 0, 0},
+		{0, 0, 1, 1, 1, 1, 0, 0, 0, 0},
+		{0, 0, 0, 0, 0, 0, 1, 1, 0, 0},
+		{0, 0, 0, 0, 0, 0, 1, 1, 0, 0},
+		{0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+	}
+
+	im := copyToRGBA(bim)
+	m := NewScaledIconMatcher(icon, 1, 2, 0.5)
+
+	if got := m.Scales(); len(got) != 3 || got[0] != 1 || got[2] != 2 {
+		t.Fatalf("bad scales: %v", got)
+	}
+
+	loc := image.Pt(5, 4)
+	if st := m.MatchStrength(loc.X, loc.Y, im); st != 1 {
+		t.Errorf("failed to find icon at %v: strength is %f", loc, st)
+	}
+	if s := m.MatchScale(loc.X, loc.Y, im); s != 2 {
+		t.Errorf("matched at scale %f, want 2", s)
+	}
+
+	tr, err := NewNode(NodeConfig{Classes: []NodeClass{{Matcher: m, StrengthThreshold: 0.9, MaxCount: 1}}}, im, t.Logf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.Find(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if ns := tr.Graph().Nodes; len(ns) != 1 || ns[0].Scale != 2 {
+		t.Errorf("want one node at scale 2, have %v", ns)
+	}
+
+	m.EraseMatch(loc.X, loc.Y, im)
+	for y := 1; y < 7; y++ {
+		for x := 2; x < 8; x++ {
+			if c := im.RGBAAt(x, y); c.A != 0 {
+				t.Errorf("pixel (%d, %d) not erased: %v; image:\n%s", x, y, c, imToString(im))
+			}
+		}
+	}
+}
@@ -0,0 +1,432 @@
+package tracer
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"math"
+	"sort"
+)
+
+// Lines may turn by at most this much where they meet other lines.
+const maxJunctionTurnDeg = 60
+
+// The direction of a branch at a junction is measured this many pixels
+// away from the junction, so that the blob left by thinning where lines
+// meet does not skew it.
+const junctionArmPx = 10
+
+// Lines are followed across at most this many branches from each node,
+// since the ways through dense junctions grow exponentially.
+const maxFollowedBranches = 10000
+
+// findSkeletonLinks links nodes that are joined by lines in the skeleton
+// of the image.
+//
+// Pixels close to the line color are thinned to one pixel wide curves
+// with the Zhang-Suen algorithm, so the width of lines and their
+// anti-aliasing do not matter. The curves are split into branches at
+// junctions and at node markers (pixels within NodeProximityPx of a
+// node). Starting from each node, branches are followed through
+// junctions as long as they turn by at most maxJunctionTurnDeg, so a
+// line that forks into two links both of its ends, but the ends do not
+// link each other.
+func (t *LinkTracer) findSkeletonLinks(ctx context.Context) error {
+	b := t.im.Bounds()
+	w, h := b.Dx(), b.Dy()
+	lc := &t.c
+
+	classes := lc.lineClasses()
+	pixels, bridged, err := t.linePixels(ctx, t.pixelMatcher(t.im, classes), len(classes))
+	if err != nil {
+		return err
+	}
+	nearNode := nodeRegions(t.g.Nodes, b, float64(lc.NodeProximityPx))
+
+	for ci, cl := range classes {
+		s := skeleton{bounds: b, on: make([]bool, w*h)}
+		for _, p := range pixels[ci] {
+			s.on[(p.Y-b.Min.Y)*w+p.X-b.Min.X] = true
+		}
+
+		stage := fmt.Sprintf("thinning %s lines", className(cl.Name))
+		lc.Progress.report(stage, 0, 1)
+		if err := s.thin(ctx); err != nil {
+			return err
+		}
+		lc.Progress.report(stage, 1, 1)
+
+		s.split(t.g.Nodes, nearNode)
+		t.log("%s skeleton has %d branches between %d junctions", className(cl.Name), len(s.branches), len(s.centers)-len(t.g.Nodes))
+
+		found := make([][]Link, len(t.g.Nodes))
+		truncated := make([]bool, len(t.g.Nodes))
+		stage = fmt.Sprintf("following %s lines from nodes", className(cl.Name))
+		err = forEach(ctx, len(t.g.Nodes), stage, lc.Progress, func(i int) {
+			found[i], truncated[i] = s.linksFrom(i, len(t.g.Nodes))
+		})
+		if err != nil {
+			return err
+		}
+		for i, tr := range truncated {
+			if tr {
+				n := t.g.Nodes[i].Pt()
+				t.log("stopped following %s lines from node (%d, %d) after %d branches, some of its links may be missing",
+					className(cl.Name), n.X, n.Y, maxFollowedBranches)
+			}
+		}
+
+		// A link may be found along several routes. Keep the shortest,
+		// unless looking for parallel links, which dedupeLinks tells
+		// apart.
+		var links []Link
+		best := make(map[[2]int]int)
+		for _, ls := range found {
+			for _, l := range ls {
+				if lc.ParallelSepPx > 0 {
+					links = append(links, l)
+					continue
+				}
+				k := [2]int{l.Src, l.Dst}
+				if i, ok := best[k]; !ok {
+					best[k] = len(links)
+					links = append(links, l)
+				} else if len(l.Points) < len(links[i].Points) {
+					links[i] = l
+				}
+			}
+		}
+		for _, l := range links {
+			l.Class = cl.Name
+			l.Dashed = isDashed(l.Points, bridged[ci], b)
+			l.Points = append([]image.Point{t.g.Nodes[l.Src].Pt()}, l.Points...)
+			l.Points = append(l.Points, t.g.Nodes[l.Dst].Pt())
+			t.g.Links = append(t.g.Links, l)
+		}
+	}
+	sort.SliceStable(t.g.Links, func(i, j int) bool {
+		li, lj := t.g.Links[i], t.g.Links[j]
+		if li.Src != lj.Src {
+			return li.Src < lj.Src
+		}
+		if li.Dst != lj.Dst {
+			return li.Dst < lj.Dst
+		}
+		return li.Class < lj.Class
+	})
+	return nil
+}
+
+// A skeleton is a set of one pixel wide curves split into branches.
+type skeleton struct {
+	bounds image.Rectangle
+	on     []bool // indexed row by row from bounds.Min
+
+	// Vertices are the nodes, followed by junctions. vertex is the
+	// vertex that each pixel belongs to, or -1.
+	vertex   []int32
+	centers  []vec2 // of each vertex
+	branches []branch
+	incident [][]int // branches of each vertex
+}
+
+// A branch is a curve between two vertices. Its points start in vertex
+// a and end in vertex b.
+type branch struct {
+	a, b   int
+	points []image.Point
+}
+
+// neighbors of a pixel in clockwise order, starting above it.
+var skelNeighbors = [8]image.Point{
+	{0, -1}, {1, -1}, {1, 0}, {1, 1}, {0, 1}, {-1, 1}, {-1, 0}, {-1, -1},
+}
+
+func (s *skeleton) get(x, y int) bool {
+	w := s.bounds.Dx()
+	if x < 0 || y < 0 || x >= w || y >= s.bounds.Dy() {
+		return false
+	}
+	return s.on[y*w+x]
+}
+
+// thin removes pixels from the edges of the foreground until it is one
+// pixel wide, using the Zhang-Suen algorithm.
+func (s *skeleton) thin(ctx context.Context) error {
+	w := s.bounds.Dx()
+	var fg []int
+	for i, on := range s.on {
+		if on {
+			fg = append(fg, i)
+		}
+	}
+
+	var p [8]bool
+	var remove []int
+	for changed := true; changed; {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		changed = false
+		for step := 0; step < 2; step++ {
+			remove = remove[:0]
+			for _, i := range fg {
+				if !s.on[i] {
+					continue
+				}
+				x, y := i%w, i/w
+				n := 0
+				for k, d := range skelNeighbors {
+					p[k] = s.get(x+d.X, y+d.Y)
+					if p[k] {
+						n++
+					}
+				}
+				if n < 2 || n > 6 {
+					continue
+				}
+				transitions := 0
+				for k := range p {
+					if !p[k] && p[(k+1)%8] {
+						transitions++
+					}
+				}
+				if transitions != 1 {
+					continue
+				}
+				// p[0], p[2], p[4], and p[6] are above, right, below,
+				// and left.
+				if step == 0 && (p[0] && p[2] && p[4] || p[2] && p[4] && p[6]) {
+					continue
+				}
+				if step == 1 && (p[0] && p[2] && p[6] || p[0] && p[4] && p[6]) {
+					continue
+				}
+				remove = append(remove, i)
+			}
+			for _, i := range remove {
+				s.on[i] = false
+			}
+			if len(remove) > 0 {
+				changed = true
+			}
+		}
+
+		live := fg[:0]
+		for _, i := range fg {
+			if s.on[i] {
+				live = append(live, i)
+			}
+		}
+		fg = live
+	}
+	return nil
+}
+
+// adjacent returns the pixels of the skeleton next to pixel i. Diagonal
+// neighbors are skipped if they are also next to one of i's other
+// neighbors, so that the corners of staircases are not junctions.
+func (s *skeleton) adjacent(i int, buf []int) []int {
+	w := s.bounds.Dx()
+	x, y := i%w, i/w
+	buf = buf[:0]
+	for _, d := range skelNeighbors {
+		if !s.get(x+d.X, y+d.Y) {
+			continue
+		}
+		if d.X != 0 && d.Y != 0 && (s.get(x+d.X, y) || s.get(x, y+d.Y)) {
+			continue
+		}
+		buf = append(buf, (y+d.Y)*w+x+d.X)
+	}
+	return buf
+}
+
+// split divides the skeleton into branches between the nodes, whose
+// pixels are given by nearNode, and the junctions where three or more
+// branches meet.
+func (s *skeleton) split(nodes []Node, nearNode []int32) {
+	w := s.bounds.Dx()
+	s.vertex = make([]int32, len(s.on))
+	s.centers = make([]vec2, len(nodes))
+	for i, n := range nodes {
+		s.centers[i] = vec2{n.X - float64(s.bounds.Min.X), n.Y - float64(s.bounds.Min.Y)}
+	}
+
+	var buf []int
+	junction := make([]bool, len(s.on))
+	for i, on := range s.on {
+		s.vertex[i] = -1
+		if !on {
+			continue
+		}
+		if nearNode[i] >= 0 {
+			s.vertex[i] = nearNode[i]
+			continue
+		}
+		if buf = s.adjacent(i, buf); len(buf) >= 3 {
+			junction[i] = true
+		}
+	}
+
+	// Junction pixels that touch belong to the same junction.
+	for i := range s.on {
+		if !junction[i] || s.vertex[i] >= 0 {
+			continue
+		}
+		v := int32(len(s.centers))
+		var sum vec2
+		n := 0
+		stack := []int{i}
+		s.vertex[i] = v
+		for len(stack) > 0 {
+			j := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			x, y := j%w, j/w
+			sum.X += float64(x)
+			sum.Y += float64(y)
+			n++
+			for _, d := range skelNeighbors {
+				k := (y+d.Y)*w + x + d.X
+				if s.get(x+d.X, y+d.Y) && junction[k] && s.vertex[k] < 0 {
+					s.vertex[k] = v
+					stack = append(stack, k)
+				}
+			}
+		}
+		s.centers = append(s.centers, vec2{sum.X / float64(n), sum.Y / float64(n)})
+	}
+
+	// Follow the skeleton out of every vertex. Branches are found from
+	// both ends, so skip those whose first pixel was already walked and
+	// those that join two vertices directly but were already seen.
+	s.incident = make([][]int, len(s.centers))
+	walked := make([]bool, len(s.on))
+	direct := make(map[[2]int]bool)
+	var next []int
+	for i, on := range s.on {
+		if !on || s.vertex[i] < 0 {
+			continue
+		}
+		buf = s.adjacent(i, buf)
+		for _, j := range buf {
+			if s.vertex[j] == s.vertex[i] || walked[j] {
+				continue
+			}
+			if s.vertex[j] >= 0 {
+				k := [2]int{i, j}
+				if j < i {
+					k = [2]int{j, i}
+				}
+				if direct[k] {
+					continue
+				}
+				direct[k] = true
+			}
+
+			pts := []int{i}
+			prev, cur := i, j
+			end := -1
+			for {
+				pts = append(pts, cur)
+				if s.vertex[cur] >= 0 {
+					end = int(s.vertex[cur])
+					break
+				}
+				walked[cur] = true
+				next = s.adjacent(cur, next)
+				step := -1
+				for _, k := range next {
+					if k != prev && !walked[k] {
+						step = k
+					}
+				}
+				if step < 0 {
+					break // dead end
+				}
+				prev, cur = cur, step
+			}
+			if end < 0 {
+				continue
+			}
+
+			br := branch{a: int(s.vertex[i]), b: end}
+			for _, k := range pts {
+				br.points = append(br.points, image.Pt(k%w+s.bounds.Min.X, k/w+s.bounds.Min.Y))
+			}
+			s.incident[br.a] = append(s.incident[br.a], len(s.branches))
+			if br.b != br.a {
+				s.incident[br.b] = append(s.incident[br.b], len(s.branches))
+			}
+			s.branches = append(s.branches, br)
+		}
+	}
+}
+
+// from returns the points of branch bi starting at vertex v.
+func (s *skeleton) from(bi, v int) []image.Point {
+	br := &s.branches[bi]
+	if br.a == v {
+		return br.points
+	}
+	pts := make([]image.Point, len(br.points))
+	for i, p := range br.points {
+		pts[len(pts)-1-i] = p
+	}
+	return pts
+}
+
+// arm returns the direction that branch bi leaves vertex v in.
+func (s *skeleton) arm(bi, v int) vec2 {
+	pts := s.from(bi, v)
+	p := pts[imin(junctionArmPx, len(pts)-1)]
+	c := s.centers[v]
+	return vec2{float64(p.X-s.bounds.Min.X) - c.X, float64(p.Y-s.bounds.Min.Y) - c.Y}
+}
+
+// linksFrom returns the links from node src to nodes with larger indices
+// along the skeleton. There may be several links to the same node. It
+// reports whether it stopped after maxFollowedBranches branches, in which
+// case some links may be missing.
+func (s *skeleton) linksFrom(src, numNodes int) ([]Link, bool) {
+	var links []Link
+	inPath := make(map[int]bool)
+	minAngle := math.Pi - maxJunctionTurnDeg*math.Pi/180
+	followed := 0
+
+	var follow func(bi, v int, pts []image.Point)
+	follow = func(bi, v int, pts []image.Point) {
+		if followed == maxFollowedBranches {
+			return
+		}
+		followed++
+		br := &s.branches[bi]
+		end := br.b
+		if end == v {
+			end = br.a
+		}
+		pts = append(pts[:len(pts):len(pts)], s.from(bi, v)...)
+		if end < numNodes {
+			if end > src {
+				links = append(links, Link{Src: src, Dst: end, Points: pts})
+			}
+			return
+		}
+		if inPath[end] {
+			return
+		}
+		inPath[end] = true
+		in := s.arm(bi, end)
+		for _, next := range s.incident[end] {
+			if next != bi && offAngle(in, s.arm(next, end)) >= minAngle {
+				follow(next, end, pts)
+			}
+		}
+		inPath[end] = false
+	}
+
+	for _, bi := range s.incident[src] {
+		follow(bi, src, nil)
+	}
+	return links, followed == maxFollowedBranches
+}
@@ -0,0 +1,99 @@
+package tracer
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestSkeletonLinks(t *testing.T) {
+	dc := newLinkCanvas(240, 260)
+	dc.SetRGB(0, 0, 0.6)
+	dc.SetLineWidth(7)
+	// a thick curve from A to B
+	dc.MoveTo(20, 60)
+	dc.QuadraticTo(110, -10, 200, 60)
+	dc.Stroke()
+	// a line from C that forks to D and E
+	dc.MoveTo(20, 170)
+	dc.LineTo(100, 170)
+	dc.LineTo(190, 120)
+	dc.Stroke()
+	dc.MoveTo(100, 170)
+	dc.LineTo(190, 220)
+	dc.Stroke()
+
+	g := XYGraph{Nodes: []Node{
+		{X: 20, Y: 60},   // A
+		{X: 200, Y: 60},  // B
+		{X: 20, Y: 170},  // C
+		{X: 190, Y: 120}, // D
+		{X: 190, Y: 220}, // E
+		{X: 110, Y: 240}, // F, not linked
+	}}
+
+	tr := NewLink(LinkConfig{
+		Method:           Skeleton,
+		Color:            color.RGBA{0, 0, 153, 255},
+		MinColorAccuracy: 0.7,
+		NodeProximityPx:  8,
+	}, dc.Image(), &g, t.Logf)
+	if err := tr.Find(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	checkLinks(t, "links", tr.Graph().Links, [2]int{0, 1}, [2]int{2, 3}, [2]int{2, 4})
+	for _, l := range tr.Graph().Links {
+		if l.Points[0] != g.Nodes[l.Src].Pt() || l.Points[len(l.Points)-1] != g.Nodes[l.Dst].Pt() {
+			t.Errorf("link %d-%d: path goes from %v to %v", l.Src, l.Dst, l.Points[0], l.Points[len(l.Points)-1])
+		}
+	}
+
+	// The path from A to B follows the curve.
+	for _, l := range tr.Graph().Links {
+		if l.Src == 0 && l.Dst == 1 {
+			top := l.Points[0].Y
+			for _, p := range l.Points {
+				if p.Y < top {
+					top = p.Y
+				}
+			}
+			if top > 30 {
+				t.Errorf("want path from A to B to follow the curve, have %v", l.Points)
+			}
+		}
+	}
+}
+
+func TestSkeletonLinksFromTruncated(t *testing.T) {
+	// A node, then a chain of junctions with three branches between
+	// each pair, which can be followed in 3^12 ways.
+	const numJunctions = 12
+	s := skeleton{bounds: image.Rect(0, 0, 40*(numJunctions+1), 10)}
+	s.centers = append(s.centers, vec2{0, 5})
+	s.incident = append(s.incident, nil)
+	for j := 1; j <= numJunctions; j++ {
+		s.centers = append(s.centers, vec2{float64(40 * j), 5})
+		s.incident = append(s.incident, nil)
+	}
+	addBranch := func(a, b int) {
+		var pts []image.Point
+		for x := 40*a + 1; x < 40*b; x++ {
+			pts = append(pts, image.Pt(x, 5))
+		}
+		s.incident[a] = append(s.incident[a], len(s.branches))
+		s.incident[b] = append(s.incident[b], len(s.branches))
+		s.branches = append(s.branches, branch{a: a, b: b, points: pts})
+	}
+	addBranch(0, 1)
+	for j := 1; j < numJunctions; j++ {
+		for k := 0; k < 3; k++ {
+			addBranch(j, j+1)
+		}
+	}
+
+	if _, truncated := s.linksFrom(0, 1); !truncated {
+		t.Errorf("want linksFrom to stop after %d branches", maxFollowedBranches)
+	}
+}
@@ -2,11 +2,12 @@ package tracer
 
 import (
 	"container/heap"
+	"context"
+	"fmt"
 	"image"
 	"image/color"
 	"math"
 	"sort"
-	"sync/atomic"
 )
 
 type BlobMatcher interface {
@@ -14,21 +15,177 @@ type BlobMatcher interface {
 	EraseMatch(x, y int, im *image.RGBA)
 }
 
-type NodeConfig struct {
+// A Blob is a node candidate found by a BlobDetector.
+type Blob struct {
+	X, Y   float64 // center, may be fractional
+	Radius float64 // in pixels, 0 if unknown
+	Score  float64
+}
+
+// A BlobDetector finds node candidates in a whole image at once,
+// rather than scoring each pixel like a BlobMatcher.
+type BlobDetector interface {
+	Detect(im *image.RGBA) []Blob
+}
+
+// A MatchMetric selects how icon matchers score a location.
+type MatchMetric int
+
+const (
+	// MeanColorAccuracy averages 1 - colorDist over the icon's pixels.
+	MeanColorAccuracy MatchMetric = iota
+
+	// NormalizedCrossCorrelation uses the zero-mean normalized
+	// cross-correlation of the icon and image, which is insensitive to
+	// differences in brightness and contrast.
+	NormalizedCrossCorrelation
+)
+
+// A MetricMatcher is a BlobMatcher that supports metrics other than
+// MeanColorAccuracy.
+type MetricMatcher interface {
+	BlobMatcher
+
+	// WithMetric returns a copy of the matcher that scores with mm.
+	WithMetric(mm MatchMetric) BlobMatcher
+}
+
+// A NodeClass is one kind of node marker.
+// Exactly one of Matcher and Detector should be set.
+type NodeClass struct {
+	Name              string // recorded in Node.Class
 	Matcher           BlobMatcher
+	Detector          BlobDetector
 	StrengthThreshold float64
 	MaxCount          int
+
+	// If AutoThreshold is set, StrengthThreshold is only a lower bound.
+	// The threshold is chosen at the natural break in the scores of the
+	// candidates above it, and MaxCount <= 0 means no limit. If there is
+	// no clear break, StrengthThreshold is used if MaxCount is positive,
+	// and tracing fails otherwise.
+	AutoThreshold bool
+}
+
+func (cl *NodeClass) maxCount() int {
+	if cl.AutoThreshold && cl.MaxCount <= 0 {
+		return math.MaxInt32
+	}
+	return cl.MaxCount
+}
+
+// TransitClass is the name of the class of transit-only nodes.
+const TransitClass = "transit"
+
+// NodeConfig configures a NodeTracer.
+//
+// Candidates of all classes are selected together, best first, so that
+// overlapping candidates of different classes are resolved by score.
+type NodeConfig struct {
+	Classes []NodeClass
+	Metric  MatchMetric // used by Matchers
+
+	// Number of the best candidates left out because of MaxCount to
+	// record in XYGraph.RunnersUp.
+	NumRunnersUp int
+
+	// By default, selected nodes are erased from the image and the
+	// remaining candidates are rescored. If MinSeparationPx is positive,
+	// candidates closer than this to a selected node are suppressed
+	// instead, which leaves the image intact and lets touching or
+	// overlapping markers all be found.
+	MinSeparationPx float64
+
+	Mask     *Mask        // optional
+	Progress ProgressFunc // optional
+}
+
+// A LinkMethod selects how a LinkTracer finds links.
+type LinkMethod int
+
+const (
+	// LineRuns follows runs of line pixels outwards from each node and
+	// links the nodes at either end of each run.
+	LineRuns LinkMethod = iota
+
+	// PathSearch searches for the cheapest path along lines between each
+	// pair of nearby nodes and links them if the path is cheap enough.
+	PathSearch
+
+	// Skeleton thins line pixels to one pixel wide curves and links the
+	// nodes at either end of each curve.
+	Skeleton
+)
+
+// A RunTracker selects how LineRuns groups line pixels into runs.
+type RunTracker int
+
+const (
+	// LNN adds each pixel to the closest run that it does not turn too
+	// far from, and goes straight through line crossings (see
+	// LinkConfig.NarrowRunSteps).
+	LNN RunTracker = iota
+
+	// MHT keeps every way of adding pixels to runs for a few steps and
+	// then picks the one that turns the least.
+	MHT
+)
+
+// A LineClass is one kind of line, told apart from others by its color.
+type LineClass struct {
+	Name             string // recorded in Link.Class
+	Color            color.Color
+	MinColorAccuracy float64 // RGB value match, 0-1, 1 is an exact match
 }
 
 type LinkConfig struct {
+	Method  LinkMethod
+	Tracker RunTracker // for LineRuns
+
+	// If NarrowRunSteps is set, LNN steps are half of AllowedGapPx wide
+	// instead of AllowedGapPx, so that runs can reach the next point of
+	// their line, and runs may turn by up to ExpectedDirectionDeg instead
+	// of not at all. Runs then follow lines through crossings, but more
+	// wrong links are traced on some maps. MHT always takes narrow steps.
+	NarrowRunSteps bool
+
+	// Each line pixel belongs to the class whose color it matches best,
+	// and lines of each class are traced separately. If Classes is empty,
+	// Color and MinColorAccuracy make up a single unnamed class.
+	Classes          []LineClass
 	Color            color.Color
 	MinColorAccuracy float64 // RGB value match, 0-1, 1 is an exact match
-	MinWidthPx       int     // in pixels
-	AllowedGapPx     int     // max gap allowed, in pixels
-	NodeProximityPx  int     // min proximity to a node, in pixels
+
+	MinWidthPx      int // in pixels
+	AllowedGapPx    int // max gap allowed, in pixels
+	NodeProximityPx int // min proximity to a node, in pixels
 
 	// How many deg the line can move away from its current trajectory
 	ExpectedDirectionDeg float64
+
+	// If MaxDashGapPx is positive, gaps of up to this many pixels between
+	// evenly spaced dashes or dots of a line are bridged, and links along
+	// them are marked Dashed. Other gaps are still limited to
+	// AllowedGapPx.
+	MaxDashGapPx int
+
+	// Link.Route strays at most this far from the traced line, in pixels.
+	RouteTolerancePx float64
+
+	// If ParallelSepPx is positive, lines between the same nodes that are
+	// separated by a gap of at least ParallelSepPx pixels for most of
+	// their length are parallel links, and closer ones are the same line
+	// traced twice, of which only one is kept. If zero, links are kept as
+	// each method finds them.
+	ParallelSepPx float64
+
+	// For PathSearch, see findPathLinks.
+	MaxLinkLenPx float64 // only nodes closer than this are searched
+	OffLineCost  float64 // cost of a pixel off of a line, 1 on a line
+	MaxPathCost  float64 // per pixel of distance between the nodes
+
+	Mask     *Mask        // optional
+	Progress ProgressFunc // optional
 }
 
 type NodeTracer struct {
@@ -45,46 +202,97 @@ type LinkTracer struct {
 	log func(string, ...interface{})
 }
 
-func NewNode(c NodeConfig, tim image.Image, logfunc func(string, ...interface{})) *NodeTracer {
+// NewNode returns a NodeTracer for tim. It returns an error if c.Metric
+// is not MeanColorAccuracy and the matcher of a class is not a
+// MetricMatcher.
+func NewNode(c NodeConfig, tim image.Image, logfunc func(string, ...interface{})) (*NodeTracer, error) {
+	c.Classes = append([]NodeClass(nil), c.Classes...)
+	for i := range c.Classes {
+		cl := &c.Classes[i]
+		if cl.Matcher != nil && c.Metric != MeanColorAccuracy {
+			mm, ok := cl.Matcher.(MetricMatcher)
+			if !ok {
+				return nil, fmt.Errorf("%s node matcher does not support metric %d", className(cl.Name), c.Metric)
+			}
+			cl.Matcher = mm.WithMetric(c.Metric)
+		}
+	}
 	t := &NodeTracer{c: c, im: copyToRGBA(tim), log: logfunc}
 	t.g.Bounds = tim.Bounds()
-	return t
+	c.Mask.Apply(t.im)
+	return t, nil
 }
 
 func NewLink(c LinkConfig, tim image.Image, g *XYGraph, logfunc func(string, ...interface{})) *LinkTracer {
 	t := &LinkTracer{c: c, im: copyToRGBA(tim), g: *g, log: logfunc}
 	t.g.Bounds = tim.Bounds()
+	c.Mask.Apply(t.im)
 	return t
 }
 
 type Link struct {
-	Src, Dst int // index of Src and Dst Nodes
+	Src, Dst int     // index of Src and Dst Nodes
+	Class    string  `json:",omitempty"` // name of the LineClass
+	Dashed   bool    `json:",omitempty"` // drawn with dashes or dots
+	WidthPx  float64 `json:",omitempty"` // median width of the line, 0 if unknown
+
+	// Route is the path of the line from Src to Dst, simplified to a
+	// polyline, if it was traced. If the line wraps around the left and
+	// right edges of the image, CrossesSeam is set and the X coordinates
+	// of Route go past the edge instead of jumping across the image.
+	Route       []image.Point `json:",omitempty"`
+	CrossesSeam bool          `json:",omitempty"`
 
 	Points []image.Point // for debugging
 }
 
+// A Node is a node in image coordinates.
+//
+// Coordinates may be fractional if the node was located to sub-pixel
+// accuracy.
+type Node struct {
+	X, Y   float64
+	Radius float64 `json:",omitempty"` // size of the node marker in pixels, if known
+	Score  float64 `json:",omitempty"` // match strength when traced
+	Scale  float64 `json:",omitempty"` // of the icon, if matched at several scales
+	Angle  float64 `json:",omitempty"` // of the icon in degrees counter-clockwise, if matched at several angles
+	Class  string  `json:",omitempty"` // name of the NodeClass
+	Name   string  `json:",omitempty"` // label printed next to the node
+}
+
+// Pt returns the pixel containing n.
+func (n Node) Pt() image.Point {
+	return image.Pt(int(math.Round(n.X)), int(math.Round(n.Y)))
+}
+
+func lessNode(n, m Node) bool {
+	if n.X == m.X {
+		return n.Y < m.Y
+	}
+	return n.X < m.X
+}
+
 // An XYGraph is a graph with points in the original image coordinates.
 //
 // X values range from 0 to RectMax.X and Y values range from 0 to RectMax.Y.
 type XYGraph struct {
-	Nodes       []image.Point
+	Nodes       []Node
 	TransitOnly []int // Indices of nodes that are transit-only
 	Links       []Link
 
-	Bounds image.Rectangle
-}
+	// Best candidates that were not selected as nodes, in decreasing
+	// order of score. These are not part of the graph, and are dropped
+	// by unproject.ToGeoGraph.
+	RunnersUp []Node `json:",omitempty"`
 
-func (g *XYGraph) AddAsTransitOnly(g2 *XYGraph) {
-	start := len(g.Nodes)
-	g.Nodes = append(g.Nodes, g2.Nodes...)
-	for i := start; i < len(g.Nodes); i++ {
-		g.TransitOnly = append(g.TransitOnly, i)
-	}
+	Bounds image.Rectangle
 }
 
 type nodeCand struct {
 	x, y  int
 	score float64
+	class int   // index in NodeConfig.Classes
+	blob  *Blob // set if found by a BlobDetector
 }
 
 func lessPt(p, q image.Point) bool {
@@ -117,56 +325,358 @@ func (h *nodeCandHeap) Pop() interface{} {
 	return t
 }
 
-func (t *NodeTracer) Find() {
-	b := t.g.Bounds
-	nc := &t.c
+// Find finds the nodes in the image. It returns ctx.Err() if ctx is
+// cancelled before it is done.
+func (t *NodeTracer) Find(ctx context.Context) error {
+	classes := t.c.Classes
 
-	t.log("scoring candidate nodes")
-	cc := make(chan nodeCand)
-	numLeft := int32(b.Dy())
-	for y := b.Min.Y; y < b.Max.Y; y++ {
-		go func(y int) {
-			for x := b.Min.X; x < b.Max.X; x++ {
-				if score := nc.Matcher.MatchStrength(x, y, t.im); score > nc.StrengthThreshold {
-					cc <- nodeCand{x, y, score}
-				}
-			}
-			if atomic.AddInt32(&numLeft, -1) == 0 {
-				close(cc)
-			}
-		}(y)
-	}
 	var cands []nodeCand
-	for nc := range cc {
-		cands = append(cands, nc)
+	for i := range classes {
+		cc, err := t.candidates(ctx, i)
+		if err != nil {
+			return err
+		}
+		cands = append(cands, cc...)
+	}
+	floors := make([]float64, len(classes))
+	for i := range classes {
+		floors[i] = classes[i].StrengthThreshold
 	}
+	for i := range classes {
+		if classes[i].AutoThreshold {
+			if err := t.autoThresholds(ctx, cands); err != nil {
+				return err
+			}
+			break
+		}
+	}
+
 	h := nodeCandHeap(cands)
 	heap.Init(&h)
 
 	t.log("%d candidate nodes; selecting best", h.Len())
-	for len(t.g.Nodes) < nc.MaxCount && h.Len() > 0 && h[0].score > nc.StrengthThreshold {
-		top := &h[0]
-		score := nc.Matcher.MatchStrength(top.x, top.y, t.im)
+	var overflow []nodeCand
+	var err error
+	t.g.Nodes, overflow, err = t.selectNodes(ctx, &h, t.im, true)
+	if err != nil {
+		return err
+	}
+
+	// Runners-up are erased from a copy so that Image is unaffected.
+	// Candidates below automatic thresholds may also be runners-up.
+	if t.c.NumRunnersUp > 0 {
+		for i := range classes {
+			classes[i].StrengthThreshold = floors[i]
+		}
+		h = append(h, overflow...)
+		heap.Init(&h)
+		scratch := copyToRGBA(t.im)
+		for len(t.g.RunnersUp) < t.c.NumRunnersUp {
+			taken := append(append([]Node(nil), t.g.Nodes...), t.g.RunnersUp...)
+			top, ok := t.next(&h, scratch, taken, nil)
+			if !ok {
+				break
+			}
+			t.g.RunnersUp = append(t.g.RunnersUp, t.takeCand(top, scratch))
+		}
+	}
+
+	sort.Slice(t.g.Nodes, func(i, j int) bool {
+		return lessNode(t.g.Nodes[i], t.g.Nodes[j])
+	})
+	for i, n := range t.g.Nodes {
+		if n.Class == TransitClass {
+			t.g.TransitOnly = append(t.g.TransitOnly, i)
+		}
+	}
+
+	t.log("found %d nodes", len(t.g.Nodes))
+	return nil
+}
+
+// selectNodes takes the best candidates in h, up to each class's
+// MaxCount, and erases them from im. It returns the taken nodes and the
+// candidates left out. If verbose is set, it logs details of matches and
+// reports progress.
+func (t *NodeTracer) selectNodes(ctx context.Context, h *nodeCandHeap, im *image.RGBA, verbose bool) ([]Node, []nodeCand, error) {
+	classes := t.c.Classes
+	counts := make([]int, len(classes))
+	numFull := 0
+	total, unlimited := 0, false
+	for i := range classes {
+		switch n := classes[i].maxCount(); {
+		case n <= 0:
+			numFull++
+		case n == math.MaxInt32:
+			unlimited = true
+		default:
+			total += n
+		}
+	}
+	if unlimited {
+		total = UnknownTotal
+	}
+	var nodes []Node
+	var overflow []nodeCand
+	for numFull < len(classes) && h.Len() > 0 {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+		if ci := (*h)[0].class; counts[ci] >= classes[ci].maxCount() {
+			// No need to rescore candidates that cannot be taken.
+			overflow = append(overflow, heap.Pop(h).(nodeCand))
+			continue
+		}
+		top, ok := t.next(h, im, nodes, &overflow)
+		if !ok {
+			break
+		}
+		cl := &classes[top.class]
+		if counts[top.class] >= cl.maxCount() {
+			overflow = append(overflow, top)
+			continue
+		}
+		counts[top.class]++
+		if counts[top.class] >= cl.maxCount() {
+			numFull++
+		}
+
+		// top is the best candidate
+		n := t.takeCand(top, im)
+		nodes = append(nodes, n)
+		if verbose {
+			if n.Scale != 0 {
+				t.log("node at (%d, %d) matched at scale %.2f", top.x, top.y, n.Scale)
+			}
+			if _, ok := cl.Matcher.(RotatedBlobMatcher); ok {
+				t.log("node at (%d, %d) matched at angle %.1f deg", top.x, top.y, n.Angle)
+			}
+			t.c.Progress.report("selecting nodes", len(nodes), total)
+		}
+	}
+	return nodes, overflow, nil
+}
+
+// autoThresholds sets the threshold of each class with AutoThreshold
+// using the scores of the nodes that would be selected with the
+// configured thresholds.
+func (t *NodeTracer) autoThresholds(ctx context.Context, cands []nodeCand) error {
+	h := nodeCandHeap(append([]nodeCand(nil), cands...))
+	heap.Init(&h)
+	nodes, _, err := t.selectNodes(ctx, &h, copyToRGBA(t.im), false)
+	if err != nil {
+		return err
+	}
+
+	for i := range t.c.Classes {
+		cl := &t.c.Classes[i]
+		if !cl.AutoThreshold {
+			continue
+		}
+		var scores []float64
+		for _, n := range nodes {
+			if n.Class == cl.Name {
+				scores = append(scores, n.Score)
+			}
+		}
+		thresh, ok := otsuThreshold(scores)
+		if !ok && cl.MaxCount <= 0 {
+			return fmt.Errorf("%s node scores do not split into two groups; set a max count to use threshold %.3f",
+				className(cl.Name), cl.StrengthThreshold)
+		}
+		if !ok {
+			t.log("%s node scores do not split into two groups, using threshold %.3f",
+				className(cl.Name), cl.StrengthThreshold)
+			continue
+		}
+		t.log("chose %s node threshold %.3f; score histogram:", className(cl.Name), thresh)
+		logHistogram(t.log, scores, thresh)
+		cl.StrengthThreshold = thresh
+	}
+	return nil
+}
+
+// next removes and returns the best candidate in h, rescored against im
+// and the nodes that have already been taken. Candidates below their
+// class's threshold are removed and, if below is not nil, appended to it.
+func (t *NodeTracer) next(h *nodeCandHeap, im *image.RGBA, taken []Node, below *[]nodeCand) (nodeCand, bool) {
+	for h.Len() > 0 {
+		top := &(*h)[0]
+		cl := &t.c.Classes[top.class]
+		if top.score <= cl.StrengthThreshold {
+			c := heap.Pop(h).(nodeCand)
+			if below != nil {
+				*below = append(*below, c)
+			}
+			continue
+		}
+		score := t.rescore(top, im, taken)
 		if top.score != score {
 			// Score has changed (some pixels belonged to another node), record and fix heap.
 			top.score = score
-			heap.Fix(&h, 0)
+			heap.Fix(h, 0)
 			continue
 		}
+		return heap.Pop(h).(nodeCand), true
+	}
+	return nodeCand{}, false
+}
 
-		// top is the best candidate
-		t.g.Nodes = append(t.g.Nodes, image.Pt(top.x, top.y))
-		nc.Matcher.EraseMatch(top.x, top.y, t.im)
+func (t *NodeTracer) rescore(c *nodeCand, im *image.RGBA, taken []Node) float64 {
+	if sep := t.c.MinSeparationPx; sep > 0 {
+		x, y := float64(c.x), float64(c.y)
+		if c.blob != nil {
+			x, y = c.blob.X, c.blob.Y
+		}
+		for _, n := range taken {
+			if math.Hypot(n.X-x, n.Y-y) < sep {
+				return 0
+			}
+		}
+		return c.score
+	}
+	if c.blob == nil {
+		return t.c.Classes[c.class].Matcher.MatchStrength(c.x, c.y, im)
+	}
+	// Detected blobs are not affected by erasure, so instead check
+	// whether they overlap with a taken node.
+	for _, n := range taken {
+		if math.Hypot(n.X-c.blob.X, n.Y-c.blob.Y) < math.Max(c.blob.Radius, n.Radius) {
+			return 0
+		}
+	}
+	return c.blob.Score
+}
+
+// takeCand erases c from im, unless using MinSeparationPx, and returns
+// it as a Node.
+func (t *NodeTracer) takeCand(c nodeCand, im *image.RGBA) Node {
+	cl := &t.c.Classes[c.class]
+	erase := t.c.MinSeparationPx <= 0
+	if c.blob != nil {
+		// Erase the blob so that overlapping matches of other classes
+		// are rescored.
+		if erase {
+			eraseDisk(c.blob.X, c.blob.Y, c.blob.Radius, im)
+		}
+		return Node{X: c.blob.X, Y: c.blob.Y, Radius: c.blob.Radius, Score: c.score, Class: cl.Name}
+	}
+	dx, dy := t.refineSubpixel(cl.Matcher, c.x, c.y, c.score, im)
+	n := Node{X: float64(c.x) + dx, Y: float64(c.y) + dy, Score: c.score, Class: cl.Name}
+	if sm, ok := cl.Matcher.(ScaledBlobMatcher); ok {
+		n.Scale = sm.MatchScale(c.x, c.y, im)
+	}
+	if rm, ok := cl.Matcher.(RotatedBlobMatcher); ok {
+		n.Angle = rm.MatchAngle(c.x, c.y, im)
+	}
+	if erase {
+		cl.Matcher.EraseMatch(c.x, c.y, im)
+	}
+	return n
+}
 
-		// Remove top
-		heap.Remove(&h, 0)
+func eraseDisk(cx, cy, r float64, im *image.RGBA) {
+	b := image.Rect(int(cx-r), int(cy-r), int(cx+r)+2, int(cy+r)+2).Intersect(im.Rect)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if math.Hypot(float64(x)-cx, float64(y)-cy) <= r {
+				im.SetRGBA(x, y, color.RGBA{})
+			}
+		}
 	}
+}
 
-	sort.Slice(t.g.Nodes, func(i, j int) bool {
-		return lessPt(t.g.Nodes[i], t.g.Nodes[j])
+// refineSubpixel fits a parabola to the match strength around (x, y)
+// in each direction and returns the offset of its peak.
+func (t *NodeTracer) refineSubpixel(m BlobMatcher, x, y int, score float64, im *image.RGBA) (dx, dy float64) {
+	dx = parabolaPeak(m.MatchStrength(x-1, y, im), score, m.MatchStrength(x+1, y, im))
+	dy = parabolaPeak(m.MatchStrength(x, y-1, im), score, m.MatchStrength(x, y+1, im))
+	return dx, dy
+}
+
+// parabolaPeak returns the offset, in [-0.5, 0.5], of the peak of the
+// parabola through (-1, prev), (0, cur), and (1, next).
+// If cur is not a peak, it returns 0.
+func parabolaPeak(prev, cur, next float64) float64 {
+	denom := prev - 2*cur + next
+	if denom >= 0 {
+		return 0
+	}
+	off := (prev - next) / (2 * denom)
+	return math.Max(-0.5, math.Min(0.5, off))
+}
+
+// candidates returns the candidates of class ci whose scores are above
+// the class's threshold.
+func (t *NodeTracer) candidates(ctx context.Context, ci int) ([]nodeCand, error) {
+	cl := &t.c.Classes[ci]
+	mask := t.c.Mask
+
+	// Detectors and strength maps work on the whole image at once, so
+	// they can only be cancelled once done.
+	if cl.Detector != nil {
+		stage := fmt.Sprintf("detecting candidate %s nodes", className(cl.Name))
+		t.log("%s", stage)
+		t.c.Progress.report(stage, 0, 1)
+		var cands []nodeCand
+		for _, b := range cl.Detector.Detect(t.im) {
+			b := b
+			p := Node{X: b.X, Y: b.Y}.Pt()
+			if b.Score > cl.StrengthThreshold && mask.Allowed(p.X, p.Y) {
+				cands = append(cands, nodeCand{x: p.X, y: p.Y, score: b.Score, class: ci, blob: &b})
+			}
+		}
+		t.c.Progress.report(stage, 1, 1)
+		return cands, ctx.Err()
+	}
+
+	b := t.g.Bounds
+	stage := fmt.Sprintf("scoring candidate %s nodes", className(cl.Name))
+	if sm, ok := cl.Matcher.(StrengthMapper); ok {
+		t.log("%s (strength map)", stage)
+		t.c.Progress.report(stage, 0, 1)
+		var cands []nodeCand
+		strengths := sm.StrengthMap(t.im, cl.StrengthThreshold)
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				score := strengths[(y-b.Min.Y)*b.Dx()+x-b.Min.X]
+				if score > cl.StrengthThreshold && mask.Allowed(x, y) {
+					cands = append(cands, nodeCand{x: x, y: y, score: score, class: ci})
+				}
+			}
+		}
+		t.c.Progress.report(stage, 1, 1)
+		return cands, ctx.Err()
+	}
+
+	t.log("%s", stage)
+	rows := make([][]nodeCand, b.Dy())
+	err := forEach(ctx, b.Dy(), stage, t.c.Progress, func(i int) {
+		y := b.Min.Y + i
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if !mask.Allowed(x, y) {
+				continue
+			}
+			if score := cl.Matcher.MatchStrength(x, y, t.im); score > cl.StrengthThreshold {
+				rows[i] = append(rows[i], nodeCand{x: x, y: y, score: score, class: ci})
+			}
+		}
 	})
+	if err != nil {
+		return nil, err
+	}
+	var cands []nodeCand
+	for _, r := range rows {
+		cands = append(cands, r...)
+	}
+	return cands, nil
+}
 
-	t.log("found %d nodes", len(t.g.Nodes))
+func className(name string) string {
+	if name == "" {
+		return "default"
+	}
+	return name
 }
 
 func (t *NodeTracer) Image() image.Image {
@@ -175,21 +685,64 @@ func (t *NodeTracer) Image() image.Image {
 	return i
 }
 
-func (t *LinkTracer) Find() {
-	// Bad, should do a search from src to dst nodes
-	lineRuns := t.findLineRuns(t.im)
+// Find finds the links between nodes in the image. It returns ctx.Err()
+// if ctx is cancelled before it is done.
+func (t *LinkTracer) Find(ctx context.Context) error {
+	var err error
+	first := len(t.g.Links)
+	switch t.c.Method {
+	case LineRuns:
+		err = t.findRunLinks(ctx)
+	case PathSearch:
+		err = t.findPathLinks(ctx)
+	case Skeleton:
+		err = t.findSkeletonLinks(ctx)
+	default:
+		panic("unknown link method")
+	}
+	if err != nil {
+		return err
+	}
+	if t.c.ParallelSepPx > 0 {
+		t.g.Links = append(t.g.Links[:first], t.dedupeLinks(t.g.Links[first:])...)
+	}
+	t.measureWidths(t.g.Links[first:])
+	t.fitRoutes(t.g.Links[first:])
+	t.log("found %d links", len(t.g.Links))
+	return nil
+}
+
+func (t *LinkTracer) findRunLinks(ctx context.Context) error {
+	classes := t.c.lineClasses()
+	pixels, bridged, err := t.linePixels(ctx, t.lineMatcher(t.im, classes), len(classes))
+	if err != nil {
+		return err
+	}
 
-	t.log("filtering %d candidate links", len(lineRuns))
-	for i := 0; i < len(lineRuns); i++ {
-		r := &lineRuns[i]
-		src := closestNode(r.Src(), t.g.Nodes, float64(t.c.NodeProximityPx))
-		dst := closestNode(r.Dst(), t.g.Nodes, float64(t.c.NodeProximityPx))
-		if src < 0 || dst < 0 || src == dst {
-			continue
+	for ci, cl := range classes {
+		lineRuns, err := t.findLineRuns(ctx, t.im, pixels[ci], cl.Name)
+		if err != nil {
+			return err
+		}
+
+		t.log("filtering %d candidate %s links", len(lineRuns), className(cl.Name))
+		for i := 0; i < len(lineRuns); i++ {
+			r := &lineRuns[i]
+			src := closestNode(r.Src(), t.g.Nodes, float64(t.c.NodeProximityPx))
+			dst := closestNode(r.Dst(), t.g.Nodes, float64(t.c.NodeProximityPx))
+			if src < 0 || dst < 0 || src == dst {
+				continue
+			}
+			t.g.Links = append(t.g.Links, Link{
+				Src:    src,
+				Dst:    dst,
+				Class:  cl.Name,
+				Dashed: isDashed(r.SeenPoints, bridged[ci], t.g.Bounds),
+				Points: r.SeenPoints,
+			})
 		}
-		t.g.Links = append(t.g.Links, Link{src, dst, r.SeenPoints})
 	}
-	t.log("found %d links", len(t.g.Links))
+	return nil
 }
 
 func (t *NodeTracer) Graph() *XYGraph {
@@ -202,11 +755,11 @@ func (t *LinkTracer) Graph() *XYGraph {
 	return &g
 }
 
-func closestNode(pt image.Point, n []image.Point, maxDistPx float64) int {
+func closestNode(pt image.Point, n []Node, maxDistPx float64) int {
 	minDistIdx := -1
 	minDist := maxDistPx
 	for i := range n {
-		d := distPx(pt, n[i])
+		d := math.Hypot(float64(pt.X)-n[i].X, float64(pt.Y)-n[i].Y)
 		if d < minDist {
 			minDist = d
 			minDistIdx = i
@@ -224,6 +777,9 @@ type lineRun struct {
 
 	time    int
 	ewmaDir vec2
+
+	crossing *crossing // that the run is in, if any
+	exit     vec2      // direction the run leaves crossing in
 }
 
 func (r *lineRun) Src() image.Point  { return r.SeenPoints[0] }
@@ -237,42 +793,135 @@ func newLineRun(x, y int) lineRun {
 	}
 }
 
-func (t *LinkTracer) findLineRuns(im *image.RGBA) []lineRun {
+// lineClasses returns the classes of lines to trace.
+func (lc *LinkConfig) lineClasses() []LineClass {
+	if len(lc.Classes) > 0 {
+		return lc.Classes
+	}
+	return []LineClass{{Color: lc.Color, MinColorAccuracy: lc.MinColorAccuracy}}
+}
+
+// lineMatcher returns a function that returns the index of the class of
+// line that (x, y) is likely to be part of, or -1 if it is likely not
+// part of a line. Of the classes whose MinColorAccuracy the mean color
+// around (x, y) meets, it picks the one it matches best.
+func (t *LinkTracer) lineMatcher(im *image.RGBA, classes []LineClass) func(x, y int) int {
 	b := im.Bounds()
-	lc := &t.c
+	w := t.c.MinWidthPx
 
-	wR, wG, wB, wA := lc.Color.RGBA()
-	lineColor := color.RGBA{
-		R: uint8(wR), G: uint8(wG), B: uint8(wB), A: uint8(wA),
+	lineColors := make([]color.RGBA, len(classes))
+	for i, cl := range classes {
+		lineColors[i] = toRGBA(cl.Color)
 	}
 
-	matchesLine := func(x, y int) bool {
-		sum := 0.0
-		num := 0.0
-		for j := y - lc.MinWidthPx; j < y+lc.MinWidthPx; j++ {
-			if j < b.Min.Y || j > b.Max.Y {
-				return false
-			}
-			for i := x - lc.MinWidthPx; i < x+lc.MinWidthPx; i++ {
-				if i < b.Min.X || i > b.Max.X {
-					return false
+	return func(x, y int) int {
+		if y-w < b.Min.Y || y+w-1 > b.Max.Y || x-w < b.Min.X || x+w-1 > b.Max.X {
+			return -1
+		}
+		num := float64(4 * w * w)
+		if num == 0 {
+			return -1
+		}
+		best, bestAcc := -1, 0.0
+		for k, c := range lineColors {
+			sum := 0.0
+			for j := y - w; j < y+w; j++ {
+				for i := x - w; i < x+w; i++ {
+					// Check if color is close enough
+					imColor := im.RGBAAt(i, j)
+					if imColor.A != 0 {
+						sum += 1 - colorDist(c, imColor)
+					}
 				}
+			}
+			// Mean pixel color in a min-max width range are close enough
+			// (in both x and y directions).
+			if acc := sum / num; acc >= classes[k].MinColorAccuracy && (best < 0 || acc > bestAcc) {
+				best, bestAcc = k, acc
+			}
+		}
+		return best
+	}
+}
 
-				// Check if color is close enough
-				imColor := im.RGBAAt(i, j)
-				if imColor.A != 0 {
-					sum += 1 - colorDist(lineColor, imColor)
-				}
-				num++
+// pixelMatcher is like lineMatcher, but only looks at the color of
+// (x, y) itself.
+func (t *LinkTracer) pixelMatcher(im *image.RGBA, classes []LineClass) func(x, y int) int {
+	lineColors := make([]color.RGBA, len(classes))
+	for i, cl := range classes {
+		lineColors[i] = toRGBA(cl.Color)
+	}
+	return func(x, y int) int {
+		best, bestAcc := -1, 0.0
+		for k, c := range lineColors {
+			if acc := 1 - colorDist(c, im.RGBAAt(x, y)); acc >= classes[k].MinColorAccuracy && (best < 0 || acc > bestAcc) {
+				best, bestAcc = k, acc
 			}
 		}
-		if num == 0 {
-			return false
+		return best
+	}
+}
+
+// linePixels returns the pixels of lines of each class, in row order.
+// If dashed lines are traced, the gaps that it bridged in the lines of
+// each class are returned too, relative to the image bounds.
+func (t *LinkTracer) linePixels(ctx context.Context, match func(x, y int) int, numClasses int) ([][]image.Point, []*bitmap2, error) {
+	b := t.im.Bounds()
+	w, h := b.Dx(), b.Dy()
+	lc := &t.c
+	rows := make([][][]image.Point, h)
+	err := forEach(ctx, h, "finding line pixels", lc.Progress, func(i int) {
+		y := b.Min.Y + i
+		rows[i] = make([][]image.Point, numClasses)
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if !lc.Mask.Allowed(x, y) {
+				continue
+			}
+			if ci := match(x, y); ci >= 0 {
+				rows[i][ci] = append(rows[i][ci], image.Pt(x, y))
+			}
+		}
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	pixels := make([][]image.Point, numClasses)
+	for _, r := range rows {
+		for ci := range pixels {
+			pixels[ci] = append(pixels[ci], r[ci]...)
+		}
+	}
+	bridged := make([]*bitmap2, numClasses)
+	if lc.MaxDashGapPx <= 0 {
+		return pixels, bridged, nil
+	}
+
+	for ci, pts := range pixels {
+		onLine := newBitmap2(w, h)
+		for _, p := range pts {
+			onLine.Set(p.X-b.Min.X, p.Y-b.Min.Y)
+		}
+		bridged[ci] = bridgeDashes(onLine, w, h, float64(lc.MaxDashGapPx),
+			lc.ExpectedDirectionDeg*math.Pi/180)
+		t.log("bridged %d pixels of gaps between dashes", bridged[ci].Count())
+
+		pixels[ci] = pixels[ci][:0]
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				if onLine.Get(x, y) || bridged[ci].Get(x, y) {
+					pixels[ci] = append(pixels[ci], image.Pt(x+b.Min.X, y+b.Min.Y))
+				}
+			}
 		}
-		// Mean pixel color in a min-max width range are close enough
-		// (in both x and y directions).
-		return sum/num >= lc.MinColorAccuracy
 	}
+	return pixels, bridged, nil
+}
+
+// findLineRuns follows the line pixels of class name outwards from each
+// node.
+func (t *LinkTracer) findLineRuns(ctx context.Context, im *image.RGBA, possibleLineLocs []image.Point, name string) ([]lineRun, error) {
+	b := im.Bounds()
+	lc := &t.c
 
 	distPxWrapX := func(a, b image.Point) float64 {
 		t1 := float64(a.X - b.X)
@@ -290,80 +939,112 @@ func (t *LinkTracer) findLineRuns(im *image.RGBA) []lineRun {
 		return math.Min(noWrap, wrapped)
 	}
 
-	possibleLineLocs := make([]image.Point, 0, b.Dx()*b.Dy())
-	for y := b.Min.Y; y < b.Max.Y; y++ {
-		for x := b.Min.X; x < b.Max.X; x++ {
-			if matchesLine(x, y) {
-				possibleLineLocs = append(possibleLineLocs, image.Pt(x, y))
-			}
-		}
-	}
-	t.log("%d points that possibly belong to lines", len(possibleLineLocs))
+	t.log("%d points that possibly belong to %s lines", len(possibleLineLocs), className(name))
 
-	type nodeRuns struct {
-		nodeIdx int
-		runs    []lineRun
+	// Look for crossings far enough out that the arms of the lines are
+	// separate and gaps do not hide them.
+	onLine := newBitmap2(b.Dx(), b.Dy())
+	rel := make([]image.Point, len(possibleLineLocs))
+	for i, p := range possibleLineLocs {
+		rel[i] = p.Sub(b.Min)
+		onLine.Set(rel[i].X, rel[i].Y)
+	}
+	crossingRadius := float64(2*(2*lc.MinWidthPx+lc.AllowedGapPx) + 2)
+	nearNode := nodeRegions(t.g.Nodes, b, float64(lc.NodeProximityPx)+crossingRadius)
+	crossings, err := findCrossings(ctx, onLine, b.Dx(), b.Dy(), rel, crossingRadius, nearNode,
+		fmt.Sprintf("finding %s line crossings", className(name)), lc.Progress)
+	if err != nil {
+		return nil, err
 	}
-	cc := make(chan nodeRuns)
-	numLeft := int32(len(t.g.Nodes))
+	for i := range crossings {
+		crossings[i].center = crossings[i].center.Add(b.Min)
+	}
+	t.log("found %d line crossings", len(crossings))
 
-	for i, n := range t.g.Nodes {
-		go func(nodeIdx int, n image.Point) {
-			t.log("searching for lines which begin at node (%d, %d)", n.X, n.Y)
-			tracker := lnnTracker{
-				AllowedGapPx:    float64(lc.AllowedGapPx),
-				DistPx:          distPxWrapX,
-				EWMAPointThresh: 8,
-			}
-
-			type pointWithTime struct {
-				p    image.Point
-				dist float64
-				t    int
-			}
-
-			possibleLocs := make([]pointWithTime, len(possibleLineLocs))
-			for i, pt := range possibleLineLocs {
-				possibleLocs[i].p = pt
-				possibleLocs[i].dist = distPxWrapX(n, pt)
-				if possibleLocs[i].dist <= float64(lc.NodeProximityPx) {
-					possibleLocs[i].t = 0
-				} else {
-					possibleLocs[i].t = int(possibleLocs[i].dist /
-						float64(lc.AllowedGapPx))
-				}
+	runs := make([][]lineRun, len(t.g.Nodes))
+	stage := fmt.Sprintf("tracing %s lines from nodes", className(name))
+	err = forEach(ctx, len(t.g.Nodes), stage, lc.Progress, func(nodeIdx int) {
+		n := t.g.Nodes[nodeIdx].Pt()
+		t.log("searching for lines which begin at node (%d, %d)", n.X, n.Y)
+		type pointWithTime struct {
+			p    image.Point
+			dist float64
+			t    int
+		}
+
+		possibleLocs := make([]pointWithTime, len(possibleLineLocs))
+		for i, pt := range possibleLineLocs {
+			possibleLocs[i].p = pt
+			possibleLocs[i].dist = distPxWrapX(n, pt)
+			if possibleLocs[i].dist <= float64(lc.NodeProximityPx) {
+				possibleLocs[i].t = 0
+			} else if lc.NarrowRunSteps || lc.Tracker == MHT {
+				// Runs take at most one point per step, so steps must
+				// be narrow enough that the next step is within reach.
+				possibleLocs[i].t = 1 + int(2*possibleLocs[i].dist/
+					float64(lc.AllowedGapPx))
+			} else {
+				possibleLocs[i].t = int(possibleLocs[i].dist /
+					float64(lc.AllowedGapPx))
 			}
+		}
 
-			sort.Slice(possibleLocs, func(i, j int) bool {
-				pi := &possibleLocs[i]
-				pj := &possibleLocs[j]
-				if pi.dist == pj.dist {
-					if pi.p.Y == pj.p.Y {
-						return pi.p.X < pj.p.X
-					}
-					return pi.p.Y < pj.p.Y
+		sort.Slice(possibleLocs, func(i, j int) bool {
+			pi := &possibleLocs[i]
+			pj := &possibleLocs[j]
+			if pi.dist == pj.dist {
+				if pi.p.Y == pj.p.Y {
+					return pi.p.X < pj.p.X
 				}
-				return pi.dist < pj.dist
-			})
+				return pi.p.Y < pj.p.Y
+			}
+			return pi.dist < pj.dist
+		})
+
+		switch lc.Tracker {
+		case LNN:
+			tracker := lnnTracker{
+				AllowedGapPx:        float64(lc.AllowedGapPx),
+				DistPx:              distPxWrapX,
+				EWMAPointThresh:     8,
+				Crossings:           crossings,
+				CrossingHalfWidthPx: float64(lc.MinWidthPx + 1),
+			}
+			if lc.NarrowRunSteps {
+				tracker.AllowedAngleOffsetRad = lc.ExpectedDirectionDeg * math.Pi / 180
+			}
 			for _, pt := range possibleLocs {
 				tracker.AddPoint(pt.p, pt.t)
 			}
-			cc <- nodeRuns{nodeIdx, tracker.Runs()}
-			if atomic.AddInt32(&numLeft, -1) == 0 {
-				close(cc)
+			runs[nodeIdx] = tracker.Runs()
+		case MHT:
+			tracker := multipleHypTracker{
+				AllowedGapPx:          float64(lc.AllowedGapPx),
+				AllowedAngleOffsetRad: lc.ExpectedDirectionDeg * math.Pi / 180,
+				DistPx:                distPxWrapX,
+				EWMAPointThresh:       8,
+			}
+			for i, pt := range possibleLocs {
+				if i > 0 && pt.t != possibleLocs[i-1].t {
+					tracker.PruneAt(possibleLocs[i-1].t)
+				}
+				tracker.AddPoint(pt.p, pt.t)
 			}
-		}(i, n)
+			tracker.Finalize()
+			runs[nodeIdx] = tracker.Runs()
+		default:
+			panic("unknown run tracker")
+		}
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	runs := make([][]lineRun, len(t.g.Nodes))
-	for nr := range cc {
-		runs[nr.nodeIdx] = nr.runs
-	}
-	for i := 1; i < len(runs); i++ {
-		runs[0] = append(runs[0], runs[i]...)
+	var all []lineRun
+	for _, r := range runs {
+		all = append(all, r...)
 	}
-
-	return runs[0]
+	return all, nil
 }
 
 func removeMarkedUnordered(inLine *bitmap2, points *[]image.Point) {
@@ -450,11 +1131,17 @@ func (c *Config) findLineRuns(im *image.RGBA) []lineRun {
 }
 */
 
+// offAngle returns the angle between v1 and v2 in radians, or 0 if
+// either is zero.
 func offAngle(v1, v2 vec2) float64 {
-	norm1 := math.Hypot(v1.X, v2.Y)
+	norm1 := math.Hypot(v1.X, v1.Y)
 	norm2 := math.Hypot(v2.X, v2.Y)
+	if norm1 == 0 || norm2 == 0 {
+		return 0
+	}
 	dot := v1.X*v2.X + v1.Y*v2.Y
-	return math.Acos(dot / (norm1 * norm2))
+	// Rounding can take the cosine of (anti)parallel vectors past ±1
+	return math.Acos(math.Max(-1, math.Min(1, dot/(norm1*norm2))))
 }
 
 func distPx(a, b image.Point) float64 {
@@ -0,0 +1,228 @@
+package tracer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"testing"
+
+	"github.com/fogleman/gg"
+)
+
+func TestXYGraphReadsIntegerNodes(t *testing.T) {
+	const data = `{
+  "Nodes": [{"X": 265, "Y": 318}, {"X": 12.25, "Y": 3.5, "Radius": 4}],
+  "TransitOnly": [1],
+  "Links": [{"Src": 0, "Dst": 1}],
+  "Bounds": {"Min": {"X": 0, "Y": 0}, "Max": {"X": 1501, "Y": 851}}
+}`
+
+	var g XYGraph
+	if err := json.Unmarshal([]byte(data), &g); err != nil {
+		t.Fatal(err)
+	}
+	want := []Node{{X: 265, Y: 318}, {X: 12.25, Y: 3.5, Radius: 4}}
+	if len(g.Nodes) != len(want) {
+		t.Fatalf("want nodes %v, have %v", want, g.Nodes)
+	}
+	for i := range want {
+		if g.Nodes[i] != want[i] {
+			t.Errorf("node %d: want %v, have %v", i, want[i], g.Nodes[i])
+		}
+	}
+}
+
+func TestParabolaPeak(t *testing.T) {
+	f := func(x float64) float64 { return 1 - (x-0.3)*(x-0.3) }
+	if off := parabolaPeak(f(-1), f(0), f(1)); math.Abs(off-0.3) > 1e-9 {
+		t.Errorf("want peak at 0.3, have %f", off)
+	}
+	if off := parabolaPeak(1, 0, 1); off != 0 {
+		t.Errorf("want 0 for a minimum, have %f", off)
+	}
+	if off := parabolaPeak(0.5, 0.5, 0.5); off != 0 {
+		t.Errorf("want 0 for a flat surface, have %f", off)
+	}
+}
+
+func TestNodeTracerClasses(t *testing.T) {
+	red := color.RGBA{255, 0, 0, 255}
+	blue := color.RGBA{0, 0, 255, 255}
+
+	plain := image.NewRGBA(image.Rect(0, 0, 5, 5))
+	draw.Draw(plain, plain.Rect, image.NewUniform(red), image.ZP, draw.Src)
+	dotted := image.NewRGBA(plain.Rect)
+	draw.Draw(dotted, dotted.Rect, plain, image.ZP, draw.Src)
+	dotted.SetRGBA(2, 2, blue)
+
+	// Each icon matches the other's marker well, but not as well as its own.
+	im := image.NewRGBA(image.Rect(0, 0, 40, 20))
+	draw.Draw(im, im.Rect, image.White, image.ZP, draw.Src)
+	draw.Draw(im, image.Rect(8, 8, 13, 13), plain, image.ZP, draw.Src)
+	draw.Draw(im, image.Rect(28, 8, 33, 13), dotted, image.ZP, draw.Src)
+
+	tr, err := NewNode(NodeConfig{Classes: []NodeClass{
+		{Name: TransitClass, Matcher: NewIconMatcher(dotted), StrengthThreshold: 0.9, MaxCount: 5},
+		{Name: "pop", Matcher: NewIconMatcher(plain), StrengthThreshold: 0.9, MaxCount: 5},
+	}}, im, t.Logf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.Find(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	g := tr.Graph()
+	want := []Node{{X: 10, Y: 10, Class: "pop"}, {X: 30, Y: 10, Class: TransitClass}}
+	if len(g.Nodes) != len(want) {
+		t.Fatalf("want nodes %v, have %v", want, g.Nodes)
+	}
+	for i := range want {
+		if g.Nodes[i].Pt() != want[i].Pt() || g.Nodes[i].Class != want[i].Class {
+			t.Errorf("node %d: want %v, have %v", i, want[i], g.Nodes[i])
+		}
+	}
+	if len(g.TransitOnly) != 1 || g.TransitOnly[0] != 1 {
+		t.Errorf("want TransitOnly [1], have %v", g.TransitOnly)
+	}
+}
+
+// newLinkCanvas returns a white w x h drawing context for link tests.
+// Runs wrap around the sides of the image, so tests that trace line runs
+// leave room on the right.
+func newLinkCanvas(w, h int) *gg.Context {
+	dc := gg.NewContext(w, h)
+	dc.SetRGB(1, 1, 1)
+	dc.Clear()
+	return dc
+}
+
+// checkLinks reports the pairs of nodes in want that links does not
+// link and the links between other pairs, ignoring direction, and
+// returns the links by their pair of nodes, lower index first. Errors
+// start with what.
+func checkLinks(t *testing.T, what string, links []Link, want ...[2]int) map[[2]int][]Link {
+	t.Helper()
+	have := make(map[[2]int][]Link)
+	for _, l := range links {
+		k := [2]int{imin(l.Src, l.Dst), imax(l.Src, l.Dst)}
+		have[k] = append(have[k], l)
+	}
+	wanted := make(map[[2]int]bool)
+	for _, k := range want {
+		wanted[k] = true
+		if len(have[k]) == 0 {
+			t.Errorf("%s: missing link %v", what, k)
+		}
+	}
+	for k := range have {
+		if !wanted[k] {
+			t.Errorf("%s: unexpected link %v", what, k)
+		}
+	}
+	return have
+}
+
+func TestLinkTracerClasses(t *testing.T) {
+	dc := newLinkCanvas(500, 200)
+	dc.SetLineWidth(3)
+	dc.SetRGB(1, 0, 0)
+	dc.MoveTo(20, 50)
+	dc.LineTo(200, 50)
+	dc.LineTo(200, 150)
+	dc.Stroke()
+	dc.SetRGB(0, 0, 1)
+	dc.DrawLine(20, 150, 200, 150)
+	dc.Stroke()
+
+	g := XYGraph{Nodes: []Node{
+		{X: 20, Y: 50},   // A
+		{X: 200, Y: 50},  // B
+		{X: 20, Y: 150},  // C
+		{X: 200, Y: 150}, // D
+	}}
+	classes := []LineClass{
+		{Name: "fiber", Color: color.RGBA{255, 0, 0, 255}, MinColorAccuracy: 0.7},
+		{Name: "wave", Color: color.RGBA{0, 0, 255, 255}, MinColorAccuracy: 0.7},
+	}
+
+	for _, m := range []LinkMethod{LineRuns, PathSearch, Skeleton} {
+		tr := NewLink(LinkConfig{
+			Method:               m,
+			Classes:              classes,
+			MinWidthPx:           1,
+			AllowedGapPx:         3,
+			NodeProximityPx:      6,
+			ExpectedDirectionDeg: 60,
+			MaxLinkLenPx:         250,
+			OffLineCost:          10,
+			MaxPathCost:          2,
+		}, dc.Image(), &g, t.Logf)
+		if err := tr.Find(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		want := map[[2]int]string{{0, 1}: "fiber", {1, 3}: "fiber", {2, 3}: "wave"}
+		have := checkLinks(t, fmt.Sprintf("method %d", m), tr.Graph().Links, [2]int{0, 1}, [2]int{1, 3}, [2]int{2, 3})
+		for k, cl := range want {
+			for _, l := range have[k] {
+				if l.Class != cl {
+					t.Errorf("method %d: link %v: want class %q, have %q", m, k, cl, l.Class)
+				}
+			}
+		}
+	}
+}
+
+func TestNodeTracerMinSeparation(t *testing.T) {
+	red := color.RGBA{255, 0, 0, 255}
+	icon := image.NewRGBA(image.Rect(0, 0, 5, 5))
+	draw.Draw(icon, icon.Rect, image.NewUniform(red), image.ZP, draw.Src)
+	icon.SetRGBA(2, 2, color.RGBA{255, 255, 255, 255})
+
+	// Two markers that share a column.
+	im := image.NewRGBA(image.Rect(0, 0, 30, 20))
+	draw.Draw(im, im.Rect, image.White, image.ZP, draw.Src)
+	draw.Draw(im, image.Rect(8, 8, 13, 13), icon, image.ZP, draw.Src)
+	draw.Draw(im, image.Rect(12, 8, 17, 13), icon, image.ZP, draw.Src)
+
+	for _, tc := range []struct {
+		sep  float64
+		want int
+	}{
+		{0, 1}, // the second marker is lost to erasure
+		{3, 2},
+	} {
+		tr, err := NewNode(NodeConfig{
+			Classes: []NodeClass{{
+				Matcher:           NewIconMatcher(icon),
+				StrengthThreshold: 0.95,
+				MaxCount:          5,
+			}},
+			MinSeparationPx: tc.sep,
+		}, im, t.Logf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tr.Find(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		nodes := tr.Graph().Nodes
+		if len(nodes) != tc.want {
+			t.Errorf("separation %g: want %d nodes, have %v", tc.sep, tc.want, nodes)
+		}
+		if tc.sep > 0 {
+			if len(nodes) == 2 && (nodes[0].Pt() != image.Pt(10, 10) || nodes[1].Pt() != image.Pt(14, 10)) {
+				t.Errorf("want nodes at (10, 10) and (14, 10), have %v", nodes)
+			}
+			if tr.Image().(*image.RGBA).RGBAAt(12, 10) != red {
+				t.Errorf("image was modified")
+			}
+		}
+	}
+}
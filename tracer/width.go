@@ -0,0 +1,88 @@
+package tracer
+
+import (
+	"image"
+	"math"
+	"sort"
+)
+
+// The direction of a link at a point is measured between the points this
+// many places before and after it.
+const widthDirSpan = 3
+
+// Step in pixels when walking across a line to measure its width.
+const widthStepPx = 0.5
+
+// Widths are measured up to this many pixels, so that areas filled with
+// the line color do not take long.
+const maxWidthPx = 64
+
+// measureWidths sets the WidthPx of links to the median width of their
+// line, measured perpendicular to the link at each of its points. Points
+// near nodes and points off of the line (in gaps) are skipped, as are
+// links without any other points.
+func (t *LinkTracer) measureWidths(links []Link) {
+	classes := t.c.lineClasses()
+	match := t.pixelMatcher(t.im, classes)
+	classIdx := make(map[string]int)
+	for i, cl := range classes {
+		classIdx[cl.Name] = i
+	}
+	b := t.im.Bounds()
+	nearNode := nodeRegions(t.g.Nodes, b, float64(t.c.NodeProximityPx))
+	onLine := func(x, y float64, ci int) bool {
+		p := image.Pt(int(math.Round(x)), int(math.Round(y)))
+		return p.In(b) && match(p.X, p.Y) == ci
+	}
+
+	near := func(p image.Point) bool {
+		return p.In(b) && nearNode[(p.Y-b.Min.Y)*b.Dx()+p.X-b.Min.X] >= 0
+	}
+
+	for li := range links {
+		l := &links[li]
+		ci, ok := classIdx[l.Class]
+		if !ok {
+			continue
+		}
+		l.WidthPx = lineWidth(l.Points, func(x, y float64) bool { return onLine(x, y, ci) }, near)
+	}
+}
+
+// lineWidth returns the median width of the line along points, measured
+// perpendicular to it at each point, or 0 if it cannot be measured.
+// Points where skip is true and points off of the line are skipped.
+func lineWidth(points []image.Point, onLine func(x, y float64) bool, skip func(image.Point) bool) float64 {
+	var widths []float64
+	for i, p := range points {
+		if skip(p) || !onLine(float64(p.X), float64(p.Y)) {
+			continue
+		}
+		q := points[imax(0, i-widthDirSpan)]
+		r := points[imin(len(points)-1, i+widthDirSpan)]
+		dx, dy := float64(r.X-q.X), float64(r.Y-q.Y)
+		norm := math.Hypot(dx, dy)
+		if norm == 0 {
+			continue
+		}
+		// Unit vector across the line
+		ax, ay := -dy/norm, dx/norm
+
+		n := 1
+		for _, sign := range []float64{-1, 1} {
+			for k := 1; float64(k)*widthStepPx <= maxWidthPx; k++ {
+				d := sign * float64(k) * widthStepPx
+				if !onLine(float64(p.X)+d*ax, float64(p.Y)+d*ay) {
+					break
+				}
+				n++
+			}
+		}
+		widths = append(widths, float64(n)*widthStepPx)
+	}
+	if len(widths) == 0 {
+		return 0
+	}
+	sort.Float64s(widths)
+	return widths[len(widths)/2]
+}
@@ -0,0 +1,53 @@
+package tracer
+
+import (
+	"context"
+	"image/color"
+	"math"
+	"testing"
+)
+
+func TestLinkWidths(t *testing.T) {
+	dc := newLinkCanvas(260, 200)
+	dc.SetRGB(0, 0, 0)
+	dc.SetLineWidth(2)
+	dc.DrawLine(20, 40, 220, 40)
+	dc.Stroke()
+	dc.SetLineWidth(6)
+	dc.DrawLine(20, 100, 220, 160)
+	dc.Stroke()
+
+	g := XYGraph{Nodes: []Node{
+		{X: 20, Y: 40},   // A
+		{X: 220, Y: 40},  // B
+		{X: 20, Y: 100},  // C
+		{X: 220, Y: 160}, // D
+	}}
+	tr := NewLink(LinkConfig{
+		Method:           PathSearch,
+		Color:            color.Black,
+		MinColorAccuracy: 0.6,
+		MinWidthPx:       1,
+		AllowedGapPx:     3,
+		NodeProximityPx:  8,
+		MaxLinkLenPx:     250,
+		OffLineCost:      10,
+		MaxPathCost:      2,
+	}, dc.Image(), &g, t.Logf)
+	if err := tr.Find(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[[2]int]float64{{0, 1}: 2, {2, 3}: 6}
+	have := checkLinks(t, "links", tr.Graph().Links, [2]int{0, 1}, [2]int{2, 3})
+	for k, w := range want {
+		if len(have[k]) > 1 {
+			t.Errorf("want one link %v, have %d", k, len(have[k]))
+		}
+		for _, l := range have[k] {
+			if math.Abs(l.WidthPx-w) > 1 {
+				t.Errorf("link %v: want width %v, have %v", k, w, l.WidthPx)
+			}
+		}
+	}
+}
@@ -2,6 +2,7 @@ package unproject
 
 import (
 	"image"
+	"math"
 
 	"github.com/uluyol/tracegeog/tracer"
 )
@@ -10,28 +11,127 @@ type LatLon struct {
 	Lat, Lon float64
 }
 
+type Node struct {
+	LatLon
+	Class string `json:",omitempty"` // see tracer.Node
+	Name  string `json:",omitempty"`
+}
+
 type Link struct {
 	Src, Dst int
+	Class    string  `json:",omitempty"` // see tracer.Link
+	Dashed   bool    `json:",omitempty"`
+	WidthPx  float64 `json:",omitempty"`
+
+	// Waypoints along the drawn line from Src to Dst, if it was traced.
+	// Consecutive waypoints are well under 180° of longitude apart, so
+	// the short way between them follows the line.
+	Route []LatLon `json:",omitempty"`
+
+	// Whether the link crosses the ±180° meridian. Links without a Route
+	// are assumed to go the short way around.
+	CrossesAntimeridian bool `json:",omitempty"`
 }
 
 type GeoGraph struct {
-	Nodes       []LatLon
+	Nodes       []Node
 	TransitOnly []int // indices of nodes that are transit-only
 	Links       []Link
 }
 
-type InversionFunc = func(image.Point) LatLon
+// An InversionFunc maps image coordinates to a geographic location.
+// Coordinates may be fractional.
+type InversionFunc = func(x, y float64) LatLon
 
-func ToGeoGraph(g *tracer.XYGraph, invertFn InversionFunc) *GeoGraph {
+// ToGeoGraph unprojects the nodes and links of g, which is drawn on a map
+// of the world that is worldWidthPx pixels wide before it is cropped to
+// g.Bounds. g.RunnersUp are only for reviewing traced nodes and are left
+// out.
+func ToGeoGraph(g *tracer.XYGraph, invertFn InversionFunc, worldWidthPx float64) *GeoGraph {
 	geo := new(GeoGraph)
-	geo.Nodes = make([]LatLon, len(g.Nodes))
+	geo.Nodes = make([]Node, len(g.Nodes))
 	for i, n := range g.Nodes {
-		geo.Nodes[i] = invertFn(n)
+		geo.Nodes[i] = Node{invertFn(n.X, n.Y), n.Class, n.Name}
 	}
 	geo.TransitOnly = append([]int(nil), g.TransitOnly...)
 	geo.Links = make([]Link, len(g.Links))
 	for i, l := range g.Links {
-		geo.Links[i] = Link{Src: l.Src, Dst: l.Dst}
+		geo.Links[i] = Link{
+			Src:     l.Src,
+			Dst:     l.Dst,
+			Class:   l.Class,
+			Dashed:  l.Dashed,
+			WidthPx: l.WidthPx,
+		}
+		geo.Links[i].Route = invertRoute(l.Route, invertFn, g.Bounds, worldWidthPx)
+		if len(l.Route) > 0 {
+			geo.Links[i].CrossesAntimeridian = crossesAntimeridian(geo.Links[i].Route)
+		} else {
+			src, dst := geo.Nodes[l.Src], geo.Nodes[l.Dst]
+			geo.Links[i].CrossesAntimeridian = math.Abs(dst.Lon-src.Lon) > 180
+		}
 	}
 	return geo
 }
+
+// Route segments are split into pieces at most this fraction of the world
+// width across, so that each spans well under 180° of longitude and the
+// short way between waypoints follows the drawn line.
+const maxRouteSegmentWidth = 0.25
+
+// invertRoute maps the points of route, traced on an image with bounds b
+// cropped from a map of the world worldW pixels wide, to waypoints, adding
+// waypoints along long segments.
+//
+// The tracer unwraps routes that go past the left or right edge of the
+// image by the width of the image (see tracer.Link.Route). Those points
+// are moved by the width of the world instead, so that they are past the
+// margins that were cropped away.
+func invertRoute(route []image.Point, invertFn InversionFunc, b image.Rectangle, worldW float64) []LatLon {
+	var out []LatLon
+	add := func(x, y float64) {
+		ll := invertFn(x, y)
+		ll.Lon = normalizeLon(ll.Lon)
+		out = append(out, ll)
+	}
+	worldX := func(x int) float64 {
+		if w := b.Dx(); w > 0 && worldW > 0 {
+			wraps := int(math.Floor(float64(x-b.Min.X) / float64(w)))
+			return float64(x) + float64(wraps)*(worldW-float64(w))
+		}
+		return float64(x)
+	}
+	maxDx := maxRouteSegmentWidth * worldW
+	for i, p := range route {
+		x := worldX(p.X)
+		if i > 0 && maxDx > 0 {
+			q := route[i-1]
+			qx := worldX(q.X)
+			dx, dy := x-qx, float64(p.Y-q.Y)
+			n := int(math.Ceil(math.Abs(dx) / maxDx))
+			for k := 1; k < n; k++ {
+				t := float64(k) / float64(n)
+				add(qx+t*dx, float64(q.Y)+t*dy)
+			}
+		}
+		add(x, float64(p.Y))
+	}
+	return out
+}
+
+// crossesAntimeridian reports whether consecutive waypoints of route jump
+// more than 180° of longitude, which, as invertRoute keeps them well under
+// 180° apart, happens only where the route crosses the ±180° meridian.
+func crossesAntimeridian(route []LatLon) bool {
+	for i := 1; i < len(route); i++ {
+		if math.Abs(route[i].Lon-route[i-1].Lon) > 180 {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeLon returns lon in [-180, 180).
+func normalizeLon(lon float64) float64 {
+	return math.Mod(math.Mod(lon+180, 360)+360, 360) - 180
+}
@@ -0,0 +1,116 @@
+package unproject
+
+import (
+	"image"
+	"math"
+	"testing"
+
+	"github.com/uluyol/tracegeog/tracer"
+)
+
+func TestNormalizeLon(t *testing.T) {
+	for _, c := range []struct{ in, want float64 }{
+		{0, 0},
+		{179, 179},
+		{180, -180},
+		{-180, -180},
+		{189, -171},
+		{-200, 160},
+		{540, -180},
+	} {
+		if have := normalizeLon(c.in); math.Abs(have-c.want) > 1e-9 {
+			t.Errorf("normalizeLon(%v): want %v, have %v", c.in, c.want, have)
+		}
+	}
+}
+
+// marginMap is a map 300 pixels wide cropped from a world 400 pixels
+// wide, with the prime meridian in the middle of the world, so that
+// pixel x of the image is at longitude (x-200)*0.9.
+func marginMap() *WebMercator {
+	wm := &WebMercator{
+		Bounds:         image.Rect(0, 0, 300, 200),
+		ScaleY:         1,
+		PrimeMeridianX: 200,
+		EquatorY:       100,
+	}
+	wm.ExtraMargin.Left = 50
+	wm.ExtraMargin.Right = 50
+	return wm
+}
+
+func lonsOf(route []LatLon) []float64 {
+	var lons []float64
+	for _, ll := range route {
+		lons = append(lons, ll.Lon)
+	}
+	return lons
+}
+
+func TestInvertRouteWrapped(t *testing.T) {
+	wm := marginMap()
+
+	// A line that leaves the right edge at x = 290 and comes back in
+	// from the left edge to x = 10, as the tracer unwraps it.
+	route := []image.Point{{290, 100}, {310, 100}}
+	have := lonsOf(invertRoute(route, wm.ToLatLon, wm.Bounds, wm.WorldWidthPx()))
+	want := []float64{81, 135, -171}
+	if len(have) != len(want) {
+		t.Fatalf("want waypoints at %v, have %v", want, have)
+	}
+	for i := range want {
+		if math.Abs(have[i]-want[i]) > 1e-6 {
+			t.Errorf("want waypoints at %v, have %v", want, have)
+			break
+		}
+	}
+}
+
+func TestToGeoGraph(t *testing.T) {
+	wm := marginMap()
+	g := &tracer.XYGraph{
+		Bounds: wm.Bounds,
+		Nodes: []tracer.Node{
+			{X: 10, Y: 100},  // A
+			{X: 290, Y: 100}, // B
+		},
+		Links: []tracer.Link{
+			// B to A around the back of the world
+			{Src: 1, Dst: 0, Route: []image.Point{{290, 100}, {310, 100}}, CrossesSeam: true},
+			// A to B straight across the map
+			{Src: 0, Dst: 1, Route: []image.Point{{10, 100}, {290, 100}}},
+			// A to B without a route
+			{Src: 0, Dst: 1},
+		},
+	}
+	geo := ToGeoGraph(g, wm.ToLatLon, wm.WorldWidthPx())
+
+	if lon := geo.Nodes[0].Lon; math.Abs(lon+171) > 1e-6 {
+		t.Errorf("want A at longitude -171, have %v", lon)
+	}
+	if lon := geo.Nodes[1].Lon; math.Abs(lon-81) > 1e-6 {
+		t.Errorf("want B at longitude 81, have %v", lon)
+	}
+
+	wrapped, straight, direct := geo.Links[0], geo.Links[1], geo.Links[2]
+	if !wrapped.CrossesAntimeridian {
+		t.Errorf("want wrapped link to cross the antimeridian, route %v", lonsOf(wrapped.Route))
+	}
+	if end := wrapped.Route[len(wrapped.Route)-1].Lon; math.Abs(end-geo.Nodes[0].Lon) > 1e-6 {
+		t.Errorf("want wrapped route to end at A, ends at longitude %v", end)
+	}
+	if straight.CrossesAntimeridian {
+		t.Errorf("want straight link not to cross the antimeridian, route %v", lonsOf(straight.Route))
+	}
+	if len(straight.Route) < 4 {
+		t.Errorf("want waypoints along straight link, have %v", lonsOf(straight.Route))
+	}
+	for i := 1; i < len(straight.Route); i++ {
+		if d := math.Abs(straight.Route[i].Lon - straight.Route[i-1].Lon); d >= 180 {
+			t.Errorf("waypoints of straight link are %v° apart: %v", d, lonsOf(straight.Route))
+		}
+	}
+	if !direct.CrossesAntimeridian {
+		t.Errorf("want link without a route to go the short way, across the antimeridian")
+	}
+}
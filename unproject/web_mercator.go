@@ -33,19 +33,24 @@ type WebMercator struct {
 	EquatorY       int
 }
 
+// WorldWidthPx returns the width of the whole world in pixels, including
+// the extra margins.
+func (w *WebMercator) WorldWidthPx() float64 {
+	return float64(w.Bounds.Dx() + w.ExtraMargin.Left + w.ExtraMargin.Right)
+}
+
 func (w *WebMercator) scalingFactor() float64 {
-	width := w.Bounds.Dx() + w.ExtraMargin.Left + w.ExtraMargin.Right
-	return webMercatorWidth() / float64(width)
+	return webMercatorWidth() / w.WorldWidthPx()
 }
 
-func (w *WebMercator) ToLatLon(p image.Point) LatLon {
+func (w *WebMercator) ToLatLon(px, py float64) LatLon {
 	wbToLL := wgs84.WebMercator().To(wgs84.LonLat())
 
 	// x goes left to right, same as lat
-	x := float64(p.X - w.Bounds.Min.X - w.PrimeMeridianX)
+	x := px - float64(w.Bounds.Min.X+w.PrimeMeridianX)
 
 	// y goes down, so invert to get lon
-	y := float64(w.EquatorY-(p.Y-w.Bounds.Min.Y)) * w.ScaleY
+	y := (float64(w.EquatorY) - (py - float64(w.Bounds.Min.Y))) * w.ScaleY
 
 	c := w.scalingFactor()
 
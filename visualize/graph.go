@@ -4,6 +4,7 @@ import (
 	"image"
 	"image/color"
 	"image/draw"
+	"math"
 	"strconv"
 
 	"github.com/fogleman/gg"
@@ -12,7 +13,23 @@ import (
 	"golang.org/x/image/font/gofont/gobold"
 )
 
-func DrawGraph(g *tracer.XYGraph) image.Image {
+type Options struct {
+	// ColorByScore colors nodes from red (lowest score) to green
+	// (highest score) and draws runners-up as hollow circles, to make it
+	// easy to find marginal detections.
+	ColorByScore bool
+}
+
+var classPalette = []color.Color{
+	color.RGBA{160, 60, 200, 255}, // purple
+	color.RGBA{0, 150, 150, 255},  // teal
+	color.RGBA{230, 60, 140, 255}, // pink
+	color.RGBA{140, 90, 40, 255},  // brown
+}
+
+var classLabelColor = color.RGBA{255, 255, 255, 255} // white
+
+func DrawGraph(g *tracer.XYGraph, opts Options) image.Image {
 	ctx := gg.NewContext(g.Bounds.Dx(), g.Bounds.Dy())
 
 	font, err := truetype.Parse(gobold.TTF)
@@ -31,55 +48,121 @@ func DrawGraph(g *tracer.XYGraph) image.Image {
 
 	ctx.SetColor(lineColor)
 	ctx.SetLineWidth(3)
+	w := float64(g.Bounds.Dx())
 	for _, l := range g.Links {
-		if len(l.Points) == 0 {
-			ctx.DrawLine(
-				float64(g.Nodes[l.Src].X),
-				float64(g.Nodes[l.Src].Y),
-				float64(g.Nodes[l.Dst].X),
-				float64(g.Nodes[l.Dst].Y),
-			)
-			ctx.Stroke()
-			continue
+		src, dst := g.Nodes[l.Src], g.Nodes[l.Dst]
+		var xs, ys []float64
+		switch {
+		case len(l.Route) > 0:
+			for _, p := range l.Route {
+				xs = append(xs, float64(p.X))
+				ys = append(ys, float64(p.Y))
+			}
+		case len(l.Points) > 0:
+			for _, p := range l.Points {
+				xs = append(xs, float64(p.X))
+				ys = append(ys, float64(p.Y))
+			}
+		default:
+			dstX := dst.X
+			if l.CrossesSeam {
+				// Go the other way around
+				if dstX > src.X {
+					dstX -= w
+				} else {
+					dstX += w
+				}
+			}
+			xs = []float64{src.X, dstX}
+			ys = []float64{src.Y, dst.Y}
 		}
-		for pi := 1; pi < len(l.Points); pi++ {
-			p := l.Points[pi-1]
-			q := l.Points[pi]
-			ctx.DrawLine(
-				float64(p.X), float64(p.Y),
-				float64(q.X), float64(q.Y),
-			)
-			ctx.Stroke()
+
+		// Links that cross the seam go past one edge, draw them again
+		// shifted across the image so that they come in the other.
+		offsets := []float64{0}
+		if l.CrossesSeam {
+			offsets = []float64{-w, 0, w}
+		}
+		for _, off := range offsets {
+			for pi := 1; pi < len(xs); pi++ {
+				ctx.DrawLine(xs[pi-1]+off, ys[pi-1], xs[pi]+off, ys[pi])
+				ctx.Stroke()
+			}
 		}
 	}
 
 	isTransit := make(map[int]bool)
-
 	for _, ni := range g.TransitOnly {
 		isTransit[ni] = true
-		x := float64(g.Nodes[ni].X)
-		y := float64(g.Nodes[ni].Y)
-		ctx.SetColor(transitColor)
-		ctx.DrawCircle(x, y, 10)
+	}
+
+	// Other named classes get colors in order of appearance.
+	classColors := make(map[string]color.Color)
+	for i, n := range g.Nodes {
+		fill, label := color.Color(nodeColor), color.Color(nodeLabelColor)
+		switch {
+		case isTransit[i]:
+			fill, label = transitColor, transitLabelColor
+		case n.Class != "":
+			if _, ok := classColors[n.Class]; !ok {
+				classColors[n.Class] = classPalette[len(classColors)%len(classPalette)]
+			}
+			fill, label = classColors[n.Class], classLabelColor
+		}
+		ctx.SetColor(fill)
+		ctx.DrawCircle(n.X, n.Y, 10)
 		ctx.Fill()
-		ctx.SetColor(transitLabelColor)
-		ctx.DrawStringAnchored(strconv.Itoa(ni), x, y-1, 0.5, 0.5)
+		ctx.SetColor(label)
+		ctx.DrawStringAnchored(strconv.Itoa(i), n.X, n.Y-1, 0.5, 0.5)
 	}
 
+	if opts.ColorByScore {
+		drawScores(ctx, g)
+	}
+
+	return ctx.Image()
+}
+
+// drawScores redraws scored nodes colored by score and draws
+// runners-up as hollow circles, using the same scale.
+func drawScores(ctx *gg.Context, g *tracer.XYGraph) {
+	minScore := math.Inf(1)
+	maxScore := math.Inf(-1)
+	for _, ns := range [][]tracer.Node{g.Nodes, g.RunnersUp} {
+		for _, n := range ns {
+			if n.Score == 0 {
+				continue // not scored, e.g. added by hand
+			}
+			minScore = math.Min(minScore, n.Score)
+			maxScore = math.Max(maxScore, n.Score)
+		}
+	}
+	scoreColor := func(score float64) color.Color {
+		f := 1.0
+		if maxScore > minScore {
+			f = (score - minScore) / (maxScore - minScore)
+		}
+		return color.RGBA{uint8(255 * (1 - f)), uint8(200 * f), 0, 255}
+	}
+
+	labelColor := color.RGBA{255, 255, 255, 255} // white
 	for i, n := range g.Nodes {
-		if isTransit[i] {
+		if n.Score == 0 {
 			continue
 		}
-		x := float64(n.X)
-		y := float64(n.Y)
-		ctx.SetColor(nodeColor)
-		ctx.DrawCircle(x, y, 10)
+		ctx.SetColor(scoreColor(n.Score))
+		ctx.DrawCircle(n.X, n.Y, 10)
 		ctx.Fill()
-		ctx.SetColor(nodeLabelColor)
-		ctx.DrawStringAnchored(strconv.Itoa(i), x, y-1, 0.5, 0.5)
+		ctx.SetColor(labelColor)
+		ctx.DrawStringAnchored(strconv.Itoa(i), n.X, n.Y-1, 0.5, 0.5)
 	}
 
-	return ctx.Image()
+	ctx.SetLineWidth(2)
+	for _, n := range g.RunnersUp {
+		ctx.SetColor(scoreColor(n.Score))
+		ctx.DrawCircle(n.X, n.Y, 10)
+		ctx.Stroke()
+	}
 }
 
 func OverlayOn(im image.Image, base image.Image) image.Image {